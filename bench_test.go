@@ -0,0 +1,393 @@
+package grpcpool_test
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+import (
+	"github.com/eyjian/grpcpool"
+	"github.com/eyjian/grpcpool/grpcpooltest"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// 对比的几种实现：
+// 1) grpcpool、grpcpool-sharded：GRPCPool 本身（NewGRPCPool 和 NewShardedGRPCPool 创建的）；
+// 2) chan：第一个版本的做法，带缓冲的 chan 作为空闲连接队列，先进先出，取还时同样记录访问时间和使用时间并检查空闲超时；
+// 3) mutex：Mutex 保护的切片，后进先出，不记录时间；
+// 4) sharded：多个 mutex 池，按轮转选择，以减少锁竞争；
+// 5) multiplexed：不用池，所有协程共用一个 grpc.ClientConn（依赖 HTTP/2 的多路复用）。
+// 后四者只在此用于对比。
+
+// 各种实现的公共接口
+type benchPool interface {
+	Get(ctx context.Context) (benchConn, error)
+	Put(conn benchConn)
+	Close()
+}
+
+type benchConn interface {
+	GetClient() *grpc.ClientConn
+}
+
+// GRPCPool
+type grpcBenchPool struct {
+	pool *grpcpool.GRPCPool
+}
+
+func (this *grpcBenchPool) Get(ctx context.Context) (benchConn, error) {
+	conn, _, err := this.pool.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (this *grpcBenchPool) Put(conn benchConn) {
+	this.pool.Put(conn.(*grpcpool.GRPCConn))
+}
+
+func (this *grpcBenchPool) Close() {
+	this.pool.Close()
+}
+
+type benchClient struct {
+	client *grpc.ClientConn
+}
+
+func (this *benchClient) GetClient() *grpc.ClientConn {
+	return this.client
+}
+
+// 第一个版本的 GRPCPool：带缓冲的 chan，池空时拨号，池满时关闭，
+// used 和 idle 原子地计数，取还时记录访问时间，还时记录使用时间并按 idleTimeout 检查空闲超时
+type chanBenchPool struct {
+	server      *grpcpooltest.Server
+	clients     chan *chanBenchConn
+	accessTime  int64
+	used        int32
+	idle        int32
+	initSize    int32
+	idleTimeout int64
+}
+
+type chanBenchConn struct {
+	benchClient
+	utime time.Time
+}
+
+func newChanBenchPool(server *grpcpooltest.Server, size int) *chanBenchPool {
+	pool := &chanBenchPool{server: server, initSize: 1, idleTimeout: 10}
+	pool.clients = make(chan *chanBenchConn, size)
+	return pool
+}
+
+func (this *chanBenchPool) Get(ctx context.Context) (benchConn, error) {
+	atomic.StoreInt64(&this.accessTime, time.Now().Unix())
+	atomic.AddInt32(&this.used, 1)
+
+	select {
+	case conn := <-this.clients:
+		atomic.AddInt32(&this.idle, -1)
+		return conn, nil
+	default:
+		client, err := grpc.DialContext(ctx, grpcpooltest.ENDPOINT, this.server.DialOptions()...)
+		if err != nil {
+			atomic.AddInt32(&this.used, -1)
+			return nil, err
+		}
+		return &chanBenchConn{benchClient{client}, time.Now()}, nil
+	}
+}
+
+func (this *chanBenchPool) Put(conn benchConn) {
+	atomic.StoreInt64(&this.accessTime, time.Now().Unix())
+	atomic.AddInt32(&this.used, -1)
+	c := conn.(*chanBenchConn)
+	idle := atomic.AddInt32(&this.idle, 1)
+	utime := c.utime.Unix()
+	c.utime = time.Now()
+	if idle > this.initSize && time.Now().Unix()-utime > this.idleTimeout {
+		c.client.Close()
+		atomic.AddInt32(&this.idle, -1)
+		return
+	}
+	select {
+	case this.clients <- c:
+	default:
+		c.client.Close()
+		atomic.AddInt32(&this.idle, -1)
+	}
+}
+
+func (this *chanBenchPool) Close() {
+	close(this.clients)
+	for conn := range this.clients {
+		conn.client.Close()
+	}
+}
+
+// Mutex 保护的切片，后进先出，池空时拨号，池满时关闭
+type mutexBenchPool struct {
+	server  *grpcpooltest.Server
+	mutex   sync.Mutex
+	clients []*benchClient
+	size    int
+}
+
+func (this *mutexBenchPool) Get(ctx context.Context) (benchConn, error) {
+	this.mutex.Lock()
+	if n := len(this.clients); n > 0 {
+		client := this.clients[n-1]
+		this.clients = this.clients[:n-1]
+		this.mutex.Unlock()
+		return client, nil
+	}
+	this.mutex.Unlock()
+
+	client, err := grpc.DialContext(ctx, grpcpooltest.ENDPOINT, this.server.DialOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	return &benchClient{client}, nil
+}
+
+func (this *mutexBenchPool) Put(conn benchConn) {
+	this.mutex.Lock()
+	if len(this.clients) < this.size {
+		this.clients = append(this.clients, conn.(*benchClient))
+		this.mutex.Unlock()
+		return
+	}
+	this.mutex.Unlock()
+	conn.GetClient().Close()
+}
+
+func (this *mutexBenchPool) Close() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for _, client := range this.clients {
+		client.client.Close()
+	}
+	this.clients = nil
+}
+
+// 多个 mutexBenchPool，Get 按轮转选择，Put 放回取出时的那个
+type shardedBenchPool struct {
+	shards []*mutexBenchPool
+	next   uint32
+}
+
+type shardedBenchConn struct {
+	benchConn
+	shard *mutexBenchPool
+}
+
+func (this *shardedBenchPool) Get(ctx context.Context) (benchConn, error) {
+	shard := this.shards[atomic.AddUint32(&this.next, 1)%uint32(len(this.shards))]
+	conn, err := shard.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &shardedBenchConn{conn, shard}, nil
+}
+
+func (this *shardedBenchPool) Put(conn benchConn) {
+	sharded := conn.(*shardedBenchConn)
+	sharded.shard.Put(sharded.benchConn)
+}
+
+func (this *shardedBenchPool) Close() {
+	for _, shard := range this.shards {
+		shard.Close()
+	}
+}
+
+// 共用一个连接
+type multiplexedBenchPool struct {
+	client *benchClient
+}
+
+func (this *multiplexedBenchPool) Get(ctx context.Context) (benchConn, error) {
+	return this.client, nil
+}
+
+func (this *multiplexedBenchPool) Put(conn benchConn) {
+}
+
+func (this *multiplexedBenchPool) Close() {
+	this.client.client.Close()
+}
+
+const benchPoolSize = 1024
+
+var benchPools = []struct {
+	name    string
+	newPool func(b *testing.B, server *grpcpooltest.Server) benchPool
+}{
+	{"grpcpool", func(b *testing.B, server *grpcpooltest.Server) benchPool {
+		return &grpcBenchPool{server.NewPool(1, benchPoolSize, benchPoolSize)}
+	}},
+	{"grpcpool-sharded", func(b *testing.B, server *grpcpooltest.Server) benchPool {
+		return &grpcBenchPool{grpcpool.NewShardedGRPCPool(grpcpooltest.ENDPOINT, 0, 1, benchPoolSize, benchPoolSize, server.DialOptions()...)}
+	}},
+	{"chan", func(b *testing.B, server *grpcpooltest.Server) benchPool {
+		return newChanBenchPool(server, benchPoolSize)
+	}},
+	{"mutex", func(b *testing.B, server *grpcpooltest.Server) benchPool {
+		return &mutexBenchPool{server: server, size: benchPoolSize}
+	}},
+	{"sharded", func(b *testing.B, server *grpcpooltest.Server) benchPool {
+		pool := new(shardedBenchPool)
+		for i := 0; i < 8; i++ {
+			pool.shards = append(pool.shards, &mutexBenchPool{server: server, size: benchPoolSize})
+		}
+		return pool
+	}},
+	{"multiplexed", func(b *testing.B, server *grpcpooltest.Server) benchPool {
+		client, err := grpc.DialContext(context.Background(), grpcpooltest.ENDPOINT, server.DialOptions()...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		return &multiplexedBenchPool{&benchClient{client}}
+	}},
+}
+
+// 并发度为 GOMAXPROCS 的倍数（见 testing.B 的 SetParallelism）
+var benchParallelisms = []int{1, 4, 16}
+
+// 预先建好每个协程各需一个的连接，使结果不含拨号；
+// shardedBenchPool 按轮转选择分片，所有协程可能同时落到同一个分片，因此每个分片都要建好 n 个
+func prefillBenchPool(b *testing.B, pool benchPool, n int) {
+	if sharded, ok := pool.(*shardedBenchPool); ok {
+		for _, shard := range sharded.shards {
+			prefillBenchPool(b, shard, n)
+		}
+		return
+	}
+
+	conns := make([]benchConn, n)
+	for i := range conns {
+		conn, err := pool.Get(context.Background())
+		if err != nil {
+			b.Fatal(err)
+		}
+		conns[i] = conn
+	}
+	for _, conn := range conns {
+		pool.Put(conn)
+	}
+}
+
+// 对每种实现和并发度执行 op
+func runBenchPools(b *testing.B, op func(b *testing.B, pool benchPool, conn benchConn)) {
+	for _, bp := range benchPools {
+		for _, parallelism := range benchParallelisms {
+			b.Run(fmt.Sprintf("%s/parallelism=%d", bp.name, parallelism), func(b *testing.B) {
+				server := grpcpooltest.NewServer()
+				defer server.Close()
+				pool := bp.newPool(b, server)
+				defer pool.Close()
+
+				prefillBenchPool(b, pool, parallelism*runtime.GOMAXPROCS(0))
+				dials := server.GetDialCount()
+
+				b.ReportAllocs()
+				b.SetParallelism(parallelism)
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					ctx := context.Background()
+					for pb.Next() {
+						conn, err := pool.Get(ctx)
+						if err != nil {
+							b.Error(err)
+							return
+						}
+						op(b, pool, conn)
+						pool.Put(conn)
+					}
+				})
+				b.StopTimer()
+				if n := server.GetDialCount() - dials; n != 0 {
+					b.Errorf("%d dials during the benchmark, want 0", n)
+				}
+			})
+		}
+	}
+}
+
+// 只取和还，衡量池本身的开销
+func BenchmarkGetPut(b *testing.B) {
+	runBenchPools(b, func(b *testing.B, pool benchPool, conn benchConn) {})
+}
+
+// 取、调用一次健康检查、还，衡量池在实际使用中的占比
+func BenchmarkGetInvokePut(b *testing.B) {
+	runBenchPools(b, func(b *testing.B, pool benchPool, conn benchConn) {
+		var res healthpb.HealthCheckResponse
+		if err := conn.GetClient().Invoke(context.Background(), "/grpc.health.v1.Health/Check", &healthpb.HealthCheckRequest{}, &res); err != nil {
+			b.Error(err)
+		}
+	})
+}
+
+// 多核下的锁竞争：用 -cpu 1,4,16 运行，对比单个空闲连接列表和分片的池，
+// 每个 P 4 个协程，预先建好所有连接，计时期间不应有拨号
+func BenchmarkGetPutContention(b *testing.B) {
+	for _, tc := range []struct {
+		name    string
+		newPool func(server *grpcpooltest.Server) *grpcpool.GRPCPool
+	}{
+		{"single", func(server *grpcpooltest.Server) *grpcpool.GRPCPool {
+			return server.NewPool(1, benchPoolSize, benchPoolSize)
+		}},
+		{"sharded", func(server *grpcpooltest.Server) *grpcpool.GRPCPool {
+			return grpcpool.NewShardedGRPCPool(grpcpooltest.ENDPOINT, 0, 1, benchPoolSize, benchPoolSize, server.DialOptions()...)
+		}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			const parallelism = 4
+			server := grpcpooltest.NewServer()
+			defer server.Close()
+			pool := tc.newPool(server)
+			defer pool.Close()
+
+			conns := make([]*grpcpool.GRPCConn, parallelism*runtime.GOMAXPROCS(0))
+			for i := range conns {
+				conn, _, err := pool.Get(context.Background())
+				if err != nil {
+					b.Fatal(err)
+				}
+				conns[i] = conn
+			}
+			for _, conn := range conns {
+				pool.Put(conn)
+			}
+			dials := server.GetDialCount()
+
+			b.ReportAllocs()
+			b.SetParallelism(parallelism)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				ctx := context.Background()
+				for pb.Next() {
+					conn, _, err := pool.Get(ctx)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					pool.Put(conn)
+				}
+			})
+			b.StopTimer()
+			if n := server.GetDialCount() - dials; n != 0 {
+				b.Errorf("%d dials during the benchmark, want 0", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// 时钟
+//
+// 空闲超时和高峰超时的判断、连接最近使用时间和池最近访问时间的记录，以及 releaseIdleCoroutine 的定时，
+// 都通过池的 Clock 取时间和定时，默认为真实时钟，测试时可调用 SetClock 替换为假时钟（如 grpcpooltest.FakeClock），
+// 使超时可被确定地触发。Get 的等待耗时、拨号耗时和持有时长等耗时数据总是使用真实时钟（使用真实时钟时，Get 和 Put 各只读一次时钟，见 nows）。
+
+package grpcpool
+
+import (
+	"time"
+)
+
+// 时钟接口
+type Clock interface {
+	Now() time.Time                         // 取当前时间
+	After(d time.Duration) <-chan time.Time // 同 time.After
+}
+
+// 可停止的定时器
+type Timer interface {
+	C() <-chan time.Time // 到期时收到当时的时间
+	Stop() bool          // 停止定时器，同 time.Timer 的 Stop，已到期或已停止时返回 false
+}
+
+// 可创建定时器的时钟，Clock 的实现如果同时实现了本接口，池在放弃等待时（如 releaseIdleCoroutine 重新计时）会停止定时器，
+// 否则使用 After，放弃的等待直到到期才被释放（对假时钟而言一直计入尚未到期的等待）
+type TimerClock interface {
+	NewTimer(d time.Duration) Timer // 同 time.NewTimer
+}
+
+// 真实时钟
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (this realTimer) C() <-chan time.Time {
+	return this.timer.C
+}
+
+func (this realTimer) Stop() bool {
+	return this.timer.Stop()
+}
+
+// 用 After 实现的定时器，不能停止，用于未实现 TimerClock 的 Clock
+type afterTimer struct {
+	c <-chan time.Time
+}
+
+func (this afterTimer) C() <-chan time.Time {
+	return this.c
+}
+
+func (this afterTimer) Stop() bool {
+	return false
+}
+
+// atomic.Value 要求存入的值类型一致，因此包一层
+type clockHolder struct {
+	clock Clock
+}
+
+// 设置池的时钟，传 nil 表示恢复为真实时钟，
+// 应在连接池投入使用前调用（已在等待的 releaseIdleCoroutine 会立即改用新时钟）
+func (this *GRPCPool) SetClock(clock Clock) {
+	if clock == nil {
+		clock = realClock{}
+	}
+	this.clock.Store(clockHolder{clock})
+	select {
+	case this.clockChan <- struct{}{}:
+	default:
+	}
+}
+
+// 取得池的时钟
+func (this *GRPCPool) GetClock() Clock {
+	if holder, ok := this.clock.Load().(clockHolder); ok {
+		return holder.clock
+	}
+	return realClock{}
+}
+
+// 用池的时钟创建定时器，不再等待时应调用其 Stop
+func (this *GRPCPool) newTimer(d time.Duration) Timer {
+	clock := this.GetClock()
+	if timerClock, ok := clock.(TimerClock); ok {
+		return timerClock.NewTimer(d)
+	}
+	return afterTimer{clock.After(d)}
+}
+
+// 停止定时器，timer 为 nil 时什么也不做
+func stopTimer(timer Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// 取池的时钟的当前时间
+func (this *GRPCPool) now() time.Time {
+	return this.GetClock().Now()
+}
+
+// 取池的时钟的当前时间 now 和用于计算耗时的真实时间 real，
+// 池使用真实时钟（默认）时二者为同一次读取，使 Get 和 Put 各只读一次时钟
+func (this *GRPCPool) nows() (now, real time.Time) {
+	clock := this.GetClock()
+	if _, ok := clock.(realClock); ok {
+		now = time.Now()
+		return now, now
+	}
+	return clock.Now(), time.Now()
+}
@@ -0,0 +1,141 @@
+// 异步关闭被剔除的连接
+//
+// ClientConn.Close 需要等待传输层退出，在 Put 中同步关闭会把这部分耗时加到请求路径上。
+// evict 只将连接标记为已关闭（此后 IsClosed 返回 true）并移出池，实际的关闭交给 closeCoroutine：
+// 1) 队列最多容纳 CLOSE_QUEUE_SIZE 个连接，满时在调用者的协程中同步关闭；
+// 2) 可调用成员函数 SetCloseGracePeriod 设置宽限期，连接被剔除后等待宽限期再关闭，使其上仍在进行的流得以结束；
+// 3) 池被关闭时不再等待宽限期，立即关闭队列中剩余的连接，之后被剔除的连接同步关闭；
+// 4) 关闭耗时和队列中等待关闭的连接数，通过 CloseObserver 报告。
+
+package grpcpool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 等待关闭的连接队列的容量
+const CLOSE_QUEUE_SIZE = 128
+
+// 关闭观察者，MetricObserver 的实现如果同时实现了本接口，则还会收到关闭相关的数据
+type CloseObserver interface {
+	ObserveClose(d time.Duration) // ClientConn.Close 的耗时
+	AddCloseBacklog(delta int32)  // 等待关闭的连接数加 delta（入队时为 1，关闭时为 -1），多个池共用同一观察者时累计的是它们之和
+}
+
+// 等待关闭的连接
+type closingConn struct {
+	conn   *GRPCConn
+	reason EvictReason
+	etime  time.Time // 被剔除的时间
+}
+
+// 设置被剔除的连接的关闭宽限期，默认为 0（立即关闭），可随时调用
+func (this *GRPCPool) SetCloseGracePeriod(grace time.Duration) {
+	if grace < 0 {
+		grace = 0
+	}
+	atomic.StoreInt64(&this.closeGrace, int64(grace))
+}
+
+func (this *GRPCPool) GetCloseGracePeriod() time.Duration {
+	return time.Duration(atomic.LoadInt64(&this.closeGrace))
+}
+
+// 取得等待关闭的连接数
+func (this *GRPCPool) GetCloseBacklog() int32 {
+	return atomic.LoadInt32(&this.closeBacklog)
+}
+
+// 关闭 EVICTING 状态的连接，retired 为 true 表示剔除时由池标记为已关闭（ClientConn 尚未关闭）
+func (this *GRPCPool) closeEvicted(conn *GRPCConn, reason EvictReason, retired bool) {
+	if retired {
+		this.closerMutex.RLock()
+		if !this.closerStopped {
+			// 先增后入队，使 closeCoroutine 减一时不会减为负数
+			atomic.AddInt32(&this.closeBacklog, 1)
+			this.addCloseBacklog(1)
+			select {
+			case this.closeQueue <- closingConn{conn: conn, reason: reason, etime: this.now()}:
+				this.closerMutex.RUnlock()
+				return
+			default:
+				// 队列满了，同步关闭
+				atomic.AddInt32(&this.closeBacklog, -1)
+				this.addCloseBacklog(-1)
+			}
+		}
+		this.closerMutex.RUnlock()
+	}
+	this.finishEvict(conn, reason, retired)
+}
+
+// 完成剔除：关闭 ClientConn，转为 CLOSED，并记日志和通知 EventHook
+func (this *GRPCPool) finishEvict(conn *GRPCConn, reason EvictReason, retired bool) {
+	if retired {
+		start := time.Now()
+		conn.GetClient().Close()
+		if co, ok := this.GetMetricObserver().(CloseObserver); ok {
+			co.ObserveClose(time.Since(start))
+		}
+	}
+	this.setState(conn, STATE_EVICTING, STATE_CLOSED)
+
+	switch reason {
+	case EVICT_IDLE_TIMEOUT, EVICT_PEAK_TIMEOUT, EVICT_POOL_CLOSED:
+		this.logDebug("grpcpool evict connection", "conn", conn.id, "reason", reason.String(), "age", this.now().Sub(conn.ctime))
+	case EVICT_FULL:
+		this.logWarn("grpcpool evict connection", "conn", conn.id, "reason", reason.String(), "age", this.now().Sub(conn.ctime), "peak", this.GetPeakSize())
+	default:
+		this.logInfo("grpcpool evict connection", "conn", conn.id, "reason", reason.String(), "age", this.now().Sub(conn.ctime))
+	}
+	if hook := this.eventHook; hook != nil {
+		hook.OnEvict(conn, reason)
+	}
+}
+
+func (this *GRPCPool) addCloseBacklog(delta int32) {
+	if co, ok := this.GetMetricObserver().(CloseObserver); ok {
+		co.AddCloseBacklog(delta)
+	}
+}
+
+// 按剔除的先后关闭连接，池被关闭时关闭剩余的后退出
+func (this *GRPCPool) closeCoroutine() {
+	defer this.wg.Done()
+
+	for {
+		select {
+		case <-this.done:
+			// 此后 closeEvicted 不再入队，关闭剩余的
+			this.closerMutex.Lock()
+			this.closerStopped = true
+			this.closerMutex.Unlock()
+			for {
+				select {
+				case closing := <-this.closeQueue:
+					this.closeQueued(closing)
+				default:
+					return
+				}
+			}
+		case closing := <-this.closeQueue:
+			// 按先后入队，前面的到期时间不会晚于后面的
+			if wait := this.GetCloseGracePeriod() - this.now().Sub(closing.etime); wait > 0 {
+				timer := this.newTimer(wait)
+				select {
+				case <-this.done:
+					timer.Stop()
+				case <-timer.C():
+				}
+			}
+			this.closeQueued(closing)
+		}
+	}
+}
+
+func (this *GRPCPool) closeQueued(closing closingConn) {
+	atomic.AddInt32(&this.closeBacklog, -1)
+	this.addCloseBacklog(-1)
+	this.finishEvict(closing.conn, closing.reason, true)
+}
@@ -0,0 +1,163 @@
+package grpcpool_test
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+import (
+	"github.com/eyjian/grpcpool"
+	"google.golang.org/grpc/connectivity"
+)
+
+// 等待 cond 成立，超过 1 秒返回 false
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+func TestCloseGracePeriod(t *testing.T) {
+	_, pool, mo, clock := newTestPool(t, 1, 2, 4)
+	pool.SetIdleTimeout(10)
+	pool.SetCloseGracePeriod(5 * time.Second)
+
+	conn1 := mustGet(t, pool)
+	conn2 := mustGet(t, pool)
+	pool.Put(conn1)
+	clock.Advance(11 * time.Second)
+	if errcode, _ := pool.Put(conn2); errcode != grpcpool.POOL_IDLE {
+		t.Fatalf("Put: errcode %d, want POOL_IDLE", errcode)
+	}
+
+	// 已被剔除，但宽限期内 ClientConn 仍未关闭
+	if !conn2.IsClosed() || conn2.GetPoolState() != grpcpool.STATE_EVICTING {
+		t.Errorf("evicted conn: closed %v, state %s", conn2.IsClosed(), conn2.GetPoolState())
+	}
+	if backlog := pool.GetCloseBacklog(); backlog != 1 {
+		t.Errorf("close backlog = %d, want 1", backlog)
+	}
+	checkCounts(t, pool, mo, 0, 1)
+	if !clock.WaitForWaiters(1, time.Second) {
+		t.Fatal("closeCoroutine is not waiting for the grace period")
+	}
+	if state := conn2.GetClient().GetState(); state == connectivity.Shutdown {
+		t.Errorf("ClientConn closed within the grace period")
+	}
+
+	clock.Advance(5 * time.Second)
+	if !waitFor(func() bool { return conn2.GetPoolState() == grpcpool.STATE_CLOSED }) {
+		t.Fatalf("conn not closed after the grace period, state %s", conn2.GetPoolState())
+	}
+	if state := conn2.GetClient().GetState(); state != connectivity.Shutdown {
+		t.Errorf("ClientConn state = %s, want SHUTDOWN", state)
+	}
+	metric := mo.Snapshot(false)
+	if metric.CloseBacklog != 0 || metric.CloseDuration.Snapshot(false).Count != 1 {
+		t.Errorf("close backlog %d, close count %d, want 0, 1", metric.CloseBacklog, metric.CloseDuration.Snapshot(false).Count)
+	}
+}
+
+// 池被关闭时不再等待宽限期
+func TestCloseSkipsGracePeriod(t *testing.T) {
+	_, pool, mo, clock := newTestPool(t, 1, 2, 4)
+	pool.SetIdleTimeout(10)
+	pool.SetCloseGracePeriod(time.Hour)
+
+	conn1 := mustGet(t, pool)
+	conn2 := mustGet(t, pool)
+	pool.Put(conn1)
+	clock.Advance(11 * time.Second)
+	pool.Put(conn2)
+	if backlog := pool.GetCloseBacklog(); backlog != 1 {
+		t.Fatalf("close backlog = %d, want 1", backlog)
+	}
+
+	pool.Close()
+	for _, conn := range []*grpcpool.GRPCConn{conn1, conn2} {
+		if state := conn.GetPoolState(); state != grpcpool.STATE_CLOSED {
+			t.Errorf("conn %d state after Close = %s, want closed", conn.GetID(), state)
+		}
+		if state := conn.GetClient().GetState(); state != connectivity.Shutdown {
+			t.Errorf("conn %d ClientConn state after Close = %s, want SHUTDOWN", conn.GetID(), state)
+		}
+	}
+	if backlog := pool.GetCloseBacklog(); backlog != 0 {
+		t.Errorf("close backlog = %d, want 0", backlog)
+	}
+	checkCounts(t, pool, mo, 0, 0)
+}
+
+// 异步关闭时更新 CloseBacklog，与并发的 Snapshot 和 Stats 不应有数据竞争（在 -race 下检查）
+func TestSnapshotRacingClose(t *testing.T) {
+	_, pool, mo, clock := newTestPool(t, 1, 2, 4)
+	pool.SetMaxLifetime(1)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			mo.Snapshot(false)
+			pool.Stats()
+		}
+	}()
+
+	// 每次都超过 maxLifetime，归还时被剔除并交给 closeCoroutine 关闭
+	for i := 0; i < 100; i++ {
+		conn := mustGet(t, pool)
+		clock.Advance(2 * time.Second)
+		if errcode, _ := pool.Put(conn); errcode != grpcpool.CONN_EXPIRED {
+			t.Fatalf("Put: errcode %d, want CONN_EXPIRED", errcode)
+		}
+	}
+	close(stop)
+	<-done
+
+	if !waitFor(func() bool { return pool.GetCloseBacklog() == 0 }) {
+		t.Fatalf("close backlog = %d, want 0", pool.GetCloseBacklog())
+	}
+	if n := mo.Snapshot(false).PutOld; n != 100 {
+		t.Errorf("PutOld = %d, want 100", n)
+	}
+}
+
+// 多个池共用同一观察者时，观察者的 CloseBacklog 为各池等待关闭的连接数之和
+func TestCloseBacklogShared(t *testing.T) {
+	_, pool1, mo, clock := newTestPool(t, 1, 2, 4)
+	_, pool2, _, _ := newTestPool(t, 1, 2, 4)
+	pool2.SetMetricObserver(mo)
+	pool2.SetClock(clock)
+	for _, pool := range []*grpcpool.GRPCPool{pool1, pool2} {
+		pool.SetCloseGracePeriod(5 * time.Second)
+		lease, _, err := pool.GetLease(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		lease.Discard(nil)
+	}
+	if backlog := mo.Snapshot(false).CloseBacklog; backlog != 2 {
+		t.Errorf("shared close backlog = %d, want 2", backlog)
+	}
+	if backlog := pool1.Stats().Metric.CloseBacklog; backlog != 1 {
+		t.Errorf("pool close backlog = %d, want 1", backlog)
+	}
+
+	if !clock.WaitForWaiters(2, time.Second) {
+		t.Fatal("closeCoroutines are not waiting for the grace period")
+	}
+	clock.Advance(5 * time.Second)
+	if !waitFor(func() bool { return mo.Snapshot(false).CloseBacklog == 0 }) {
+		t.Errorf("shared close backlog = %d, want 0", mo.Snapshot(false).CloseBacklog)
+	}
+}
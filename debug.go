@@ -0,0 +1,244 @@
+// 调试接口
+//
+// NewDebugHandler 返回一个 http.Handler（类似 zpages），列出所有未关闭的连接池的状态，
+// 可挂到已有的管理端口上，如：
+//
+//	mux.Handle("/debug/grpcpool", grpcpool.NewDebugHandler())
+//
+// 默认输出 HTML，请求参数带 format=json 时输出 JSON。
+
+package grpcpool
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 保留的最近拨号错误数
+const MAX_DIAL_ERRORS = 10
+
+// 拨号错误
+type DialError struct {
+	Time    time.Time // 出错时间
+	Errcode uint32    // 错误代码，如 CONN_UNAVAILABLE、CONN_DEADLINE_EXCEEDED 等
+	Error   string    // gRPC 的原始错误信息
+}
+
+// 连接的调试信息
+type DebugConn struct {
+	ID         uint64        // 连接的唯一标识（即 GRPCConn 的 GetID）
+	State      string        // connectivity 状态，如 READY、IDLE、TRANSIENT_FAILURE 等
+	PoolState  string        // 在池中的状态（见 ConnState），如 idle、leased、evicting
+	Leased     bool          // 是否被取走使用中
+	Age        time.Duration // 已存活时长
+	CreateTime time.Time     // 创建时间
+	UseTime    time.Time     // 最近使用（归还）时间，按池的 Clock 计
+	SinceUse   time.Duration // 距最近使用（归还）的时长，按池的 Clock 计
+	BorrowTime time.Time     // 被取走的时间，Leased 为 false 时为零值（与持有时长一样按实际时间计，不受池的 Clock 影响）
+	HeldFor    time.Duration // 本次已被持有的时长，Leased 为 false 时为 0
+	Borrows    int64         // 被取走的次数
+	Errors     int64         // 使用中出错的次数
+	HoldTime   time.Duration // 累计持有时长
+}
+
+// 连接池的调试信息
+type DebugInfo struct {
+	Stats      Stats
+	Health     *HealthStatus // 未开启健康检查时为 nil
+	Limit      int32         // Limiter 当前允许的并发数，未设置 Limiter 时为 0
+	Inflight   int32         // Limiter 当前进行中的调用数
+	Conns      []DebugConn   // 所有未关闭的连接，空闲的在前，按存活时长从长到短排列
+	DialErrors []DialError   // 最近的拨号错误，按时间先后排列
+	Invariants string        // CheckInvariants 发现的不一致，一致时为空（有并发操作时可能是暂时的）
+}
+
+var (
+	poolsMutex sync.Mutex
+	pools      []*GRPCPool // 所有未关闭的连接池，按创建先后排列
+)
+
+func registerPool(pool *GRPCPool) {
+	poolsMutex.Lock()
+	defer poolsMutex.Unlock()
+	pools = append(pools, pool)
+}
+
+func unregisterPool(pool *GRPCPool) {
+	poolsMutex.Lock()
+	defer poolsMutex.Unlock()
+	for i, p := range pools {
+		if p == pool {
+			pools = append(pools[:i], pools[i+1:]...)
+			break
+		}
+	}
+}
+
+// 取得所有未关闭的连接池
+func GetPools() []*GRPCPool {
+	poolsMutex.Lock()
+	defer poolsMutex.Unlock()
+	return append([]*GRPCPool(nil), pools...)
+}
+
+func (this *GRPCPool) addDialError(errcode uint32, err error) {
+	this.dialErrorsMutex.Lock()
+	defer this.dialErrorsMutex.Unlock()
+	if len(this.dialErrors) >= MAX_DIAL_ERRORS {
+		this.dialErrors = append(this.dialErrors[:0], this.dialErrors[1:]...)
+	}
+	this.dialErrors = append(this.dialErrors, DialError{Time: time.Now(), Errcode: errcode, Error: err.Error()})
+}
+
+// 取得最近的拨号错误，按时间先后排列
+func (this *GRPCPool) GetDialErrors() []DialError {
+	this.dialErrorsMutex.Lock()
+	defer this.dialErrorsMutex.Unlock()
+	return append([]DialError(nil), this.dialErrors...)
+}
+
+// 取得连接池的调试信息
+func (this *GRPCPool) GetDebugInfo() DebugInfo {
+	var info DebugInfo
+	info.Stats = this.Stats()
+	if health, ok := this.GetHealthStatus(); ok {
+		info.Health = &health
+	}
+	if limiter := this.limiter; limiter != nil {
+		info.Limit = limiter.GetLimit()
+		info.Inflight = limiter.GetInflight()
+	}
+	info.DialErrors = this.GetDialErrors()
+
+	if err := this.CheckInvariants(); err != nil {
+		info.Invariants = err.Error()
+	}
+
+	conns := this.getConns()
+	now := this.now()
+	var leased []DebugConn
+	for _, conn := range conns {
+		debugConn := DebugConn{
+			ID:         conn.GetID(),
+			State:      conn.GetClient().GetState().String(),
+			PoolState:  conn.GetPoolState().String(),
+			Age:        now.Sub(conn.ctime),
+			CreateTime: conn.ctime,
+			UseTime:    conn.GetUseTime(),
+			SinceUse:   now.Sub(conn.GetUseTime()),
+			Borrows:    conn.GetBorrowCount(),
+			Errors:     conn.GetErrorCount(),
+			HoldTime:   conn.GetHoldTime(),
+		}
+		if conn.GetPoolState() == STATE_LEASED {
+			debugConn.Leased = true
+			if btime := atomic.LoadInt64(&conn.btime); btime != 0 {
+				debugConn.BorrowTime = time.Unix(0, btime)
+				debugConn.HeldFor = time.Since(debugConn.BorrowTime)
+			}
+			leased = append(leased, debugConn)
+		} else {
+			info.Conns = append(info.Conns, debugConn)
+		}
+	}
+	sortDebugConns(info.Conns)
+	sortDebugConns(leased)
+	info.Conns = append(info.Conns, leased...)
+	return info
+}
+
+func sortDebugConns(conns []DebugConn) {
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].Age > conns[j].Age
+	})
+}
+
+// 直方图的 JSON 表示：样本数、平均值和常用百分位数
+func (this HistogramSnapshot) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Count int64
+		Mean  time.Duration
+		P50   time.Duration
+		P90   time.Duration
+		P99   time.Duration
+		Max   time.Duration
+	}{this.Count, this.Mean(), this.Percentile(0.5), this.Percentile(0.9), this.Percentile(0.99), this.Max})
+}
+
+func (this *Histogram) MarshalJSON() ([]byte, error) {
+	return this.Snapshot(false).MarshalJSON()
+}
+
+// 创建调试接口的 http.Handler
+func NewDebugHandler() http.Handler {
+	return http.HandlerFunc(serveDebug)
+}
+
+func serveDebug(w http.ResponseWriter, r *http.Request) {
+	var infos []DebugInfo
+	for _, pool := range GetPools() {
+		infos = append(infos, pool.GetDebugInfo())
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(infos)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	debugTemplate.Execute(w, infos)
+}
+
+var debugTemplate = template.Must(template.New("grpcpool").Funcs(template.FuncMap{
+	"snapshot": func(h *Histogram) HistogramSnapshot { return h.Snapshot(false) },
+	"round":    func(d time.Duration) time.Duration { return d.Round(time.Millisecond) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>grpcpool</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; }
+table { border-collapse: collapse; margin-bottom: 12px; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+th { background: #eee; }
+</style>
+</head>
+<body>
+<h1>grpcpool ({{len .}} pools)</h1>
+<p><a href="?format=json">JSON</a></p>
+{{range .}}{{$m := .Stats.Metric}}
+<h2>{{.Stats.Endpoint}}{{if .Stats.Closed}} (closed){{end}}</h2>
+{{with .Invariants}}<p style="color: red">{{.}}</p>{{end}}
+<table>
+<tr><th>used</th><th>idle</th><th>closing</th><th>init</th><th>idle size</th><th>peak</th><th>idle timeout</th><th>peak timeout</th><th>limit</th><th>inflight</th><th>health</th></tr>
+<tr><td>{{$m.Used}}</td><td>{{$m.Idle}}</td><td>{{$m.CloseBacklog}}</td><td>{{.Stats.InitSize}}</td><td>{{.Stats.IdleSize}}</td><td>{{.Stats.PeakSize}}</td><td>{{.Stats.IdleTimeout}}s</td><td>{{.Stats.PeakTimeout}}s</td><td>{{.Limit}}</td><td>{{.Inflight}}</td><td>{{with .Health}}{{.Status}} ({{.Failed}}/{{.Checked}} failed, {{.Evicted}} evicted){{else}}-{{end}}</td></tr>
+</table>
+<table>
+<tr><th>dial success</th><th>dial refused</th><th>dial timeout</th><th>dial error</th><th>get success</th><th>get empty</th><th>get limited</th><th>put success</th><th>put full</th><th>put close</th><th>put old</th><th>put idle</th><th>put misuse</th><th>evict health</th><th>evict broken</th><th>evict disconnected</th></tr>
+<tr><td>{{$m.DialSuccess}}</td><td>{{$m.DialRefused}}</td><td>{{$m.DialTimeout}}</td><td>{{$m.DialError}}</td><td>{{$m.GetSuccess}}</td><td>{{$m.GetEmpty}}</td><td>{{$m.GetLimited}}</td><td>{{$m.PutSuccess}}</td><td>{{$m.PutFull}}</td><td>{{$m.PutClose}}</td><td>{{$m.PutOld}}</td><td>{{$m.PutIdle}}</td><td>{{$m.PutMisuse}}</td><td>{{$m.EvictHealth}}</td><td>{{$m.EvictBroken}}</td><td>{{$m.EvictDisconnected}}</td></tr>
+</table>
+<table>
+<tr><th>latency</th><th>count</th><th>mean</th><th>p50</th><th>p99</th><th>max</th></tr>
+{{with snapshot $m.GetWait}}<tr><td>get wait</td><td>{{.Count}}</td><td>{{.Mean}}</td><td>{{.Percentile 0.5}}</td><td>{{.Percentile 0.99}}</td><td>{{.Max}}</td></tr>{{end}}
+{{with snapshot $m.DialDuration}}<tr><td>dial</td><td>{{.Count}}</td><td>{{.Mean}}</td><td>{{.Percentile 0.5}}</td><td>{{.Percentile 0.99}}</td><td>{{.Max}}</td></tr>{{end}}
+{{with snapshot $m.HoldTime}}<tr><td>hold</td><td>{{.Count}}</td><td>{{.Mean}}</td><td>{{.Percentile 0.5}}</td><td>{{.Percentile 0.99}}</td><td>{{.Max}}</td></tr>{{end}}
+{{with snapshot $m.CloseDuration}}<tr><td>close</td><td>{{.Count}}</td><td>{{.Mean}}</td><td>{{.Percentile 0.5}}</td><td>{{.Percentile 0.99}}</td><td>{{.Max}}</td></tr>{{end}}
+</table>
+<table>
+<tr><th>conn</th><th>state</th><th>pool state</th><th>age</th><th>since use</th><th>held for</th><th>borrows</th><th>errors</th><th>total hold</th></tr>
+{{range .Conns}}<tr><td>{{.ID}}</td><td>{{.State}}</td><td>{{.PoolState}}</td><td>{{round .Age}}</td><td>{{round .SinceUse}}</td><td>{{round .HeldFor}}</td><td>{{.Borrows}}</td><td>{{.Errors}}</td><td>{{round .HoldTime}}</td></tr>
+{{end}}</table>
+{{if .DialErrors}}<table>
+<tr><th>time</th><th>errcode</th><th>dial error</th></tr>
+{{range .DialErrors}}<tr><td>{{.Time.Format "2006-01-02 15:04:05.000"}}</td><td>{{.Errcode}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>{{end}}
+{{end}}
+</body>
+</html>
+`))
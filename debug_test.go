@@ -0,0 +1,71 @@
+package grpcpool_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+import (
+	"github.com/eyjian/grpcpool"
+)
+
+// 取得调试接口的输出
+func serveDebug(t *testing.T, query string) (string, string) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	grpcpool.NewDebugHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/grpcpool"+query, nil))
+	if recorder.Code != 200 {
+		t.Fatalf("status %d", recorder.Code)
+	}
+	return recorder.Header().Get("Content-Type"), recorder.Body.String()
+}
+
+// 存活时长和距最近使用的时长按池的 Clock 计，持有时长按实际时间计
+func TestDebugHandler(t *testing.T) {
+	_, pool, _, clock := newTestPool(t, 1, 2, 4)
+	idle := mustGet(t, pool)
+	leased := mustGet(t, pool)
+	pool.Put(idle)
+	clock.Advance(5 * time.Second)
+
+	contentType, body := serveDebug(t, "?format=json")
+	if !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("JSON content type = %q", contentType)
+	}
+	var infos []grpcpool.DebugInfo
+	if err := json.Unmarshal([]byte(body), &infos); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	var conns []grpcpool.DebugConn
+	for _, info := range infos {
+		if len(info.Conns) == 2 && info.Conns[0].ID == idle.GetID() {
+			conns = info.Conns
+		}
+	}
+	if conns == nil {
+		t.Fatalf("pool not found in %s", body)
+	}
+	// 空闲的在前
+	if conns[0].Leased || conns[0].SinceUse != 5*time.Second || conns[0].HeldFor != 0 {
+		t.Errorf("idle conn: leased %v, since use %s, held for %s, want false, 5s, 0", conns[0].Leased, conns[0].SinceUse, conns[0].HeldFor)
+	}
+	if conns[1].ID != leased.GetID() || !conns[1].Leased || conns[1].BorrowTime.IsZero() || conns[1].HeldFor < 0 || conns[1].HeldFor > time.Minute {
+		t.Errorf("leased conn: id %d, leased %v, borrowed at %s, held for %s", conns[1].ID, conns[1].Leased, conns[1].BorrowTime, conns[1].HeldFor)
+	}
+	if infos[0].Stats.Endpoint == "" {
+		t.Errorf("endpoint is empty")
+	}
+
+	contentType, body = serveDebug(t, "")
+	if !strings.HasPrefix(contentType, "text/html") {
+		t.Errorf("HTML content type = %q", contentType)
+	}
+	for _, want := range []string{"<h2>bufconn</h2>", "<td>idle</td><td>5s</td><td>5s</td><td>0s</td>", "<td>leased</td>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("HTML does not contain %q", want)
+		}
+	}
+	pool.Put(leased)
+}
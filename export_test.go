@@ -0,0 +1,27 @@
+package grpcpool
+
+import (
+	"time"
+)
+
+func init() {
+	// 正忙时不让 releaseIdleCoroutine 干扰测试，需要时调用 SetReleaseIdleInterval 或直接调用 ReleaseIdle
+	releaseIdleInterval = time.Hour
+}
+
+// 设置正忙时 releaseIdleCoroutine 推迟检查的间隔，只影响之后创建的池，返回原来的值
+func SetReleaseIdleInterval(interval time.Duration) time.Duration {
+	old := releaseIdleInterval
+	releaseIdleInterval = interval
+	return old
+}
+
+// 执行一次 releaseIdleCoroutine 的检查
+func (this *GRPCPool) ReleaseIdle() {
+	this.releaseIdle()
+}
+
+// 执行一次补充新连接
+func (this *GRPCPool) Replenish() {
+	this.replenish()
+}
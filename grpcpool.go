@@ -13,6 +13,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -45,38 +46,99 @@ const (
 	// example, a successful response from a server could have been delayed
 	// long enough for the deadline to expire.
 	CONN_DEADLINE_EXCEEDED = 8 // 连接超时
+
+	POOL_LIMITED = 9 // 超出自适应并发限制，请求被拒绝（见 SetLimiter）
+	CONN_EXPIRED = 10 // 连接存活时长超过 maxLifetime 或连接断开过（通常是服务端发送了 GOAWAY），归还时被关闭（见 SetMaxLifetime 和 EnableStateWatcher）
+	CONN_MISUSED = 11 // 重复归还或归还到其它池，被拒绝（见 MisuseError）
 )
 
 // gRPC 连接
-// 约束：同一 conn 不应同时被多个协程使用
+// 约束：同一 conn 不应同时被多个协程使用（取走期间只属于取走它的协程），
+// 但 Close、IsClosed 和各 Get 函数可与池的后台协程、调试接口等并发调用
 type GRPCConn struct {
+	// 以下 64 位字段使用原子操作（以便调试接口等并发读取），要求 8 字节对齐，因此放在最前面
+	utime    int64            // 最近使用时间（UnixNano）
+	btime    int64            // 被 Get 取走的时间（UnixNano），用于计算持有时长，为 0 表示在池中
+	borrows  int64            // 被 Get 取走的次数
+	errors   int64            // 使用中出错的次数（由 Invoke 或 IncErrorCount 增加）
+	holdTime int64            // 累计持有时长（单位：纳秒）
+	rtt      int64            // 本次使用中报告的 RPC 耗时（单位：纳秒，由 Invoke 或 ReportRTT 设置），Put 时反馈给 Limiter，为 0 表示未报告
+	generation int64          // 本次被取走的序号（即取走后的 borrows），在池中时为 0，Put 时交换为 0，同一次取走只有一个 Put 能通过
+
+	id       uint64           // 连接的唯一标识，进程内从 1 开始递增
 	endpoint string           // 服务端的端点
-	closed   bool             // 为 true 表示已被关闭，这种状态的不能再使用和放回池
+	pool     *GRPCPool        // 取出它的池，用于识别归还到其它池的误用
+	closed   int32            // 为 1 表示已被关闭，这种状态的不能再使用和放回池
 	client   *grpc.ClientConn // gRPC 连接
-	utime    time.Time        // 最近使用时间
+	ctime    time.Time        // 创建时间
+	limiter  Limiter          // 非 nil 表示 Get 时取得了该 Limiter 的许可，Put 时需归还
+	failed   int32            // 为 1 表示本次使用中 RPC 出错（由 Invoke 设置），Put 时反馈给 Limiter
+	broken   int32            // 为 1 表示连接进入过 TransientFailure 或 Shutdown 状态（开启状态监视时由监视协程设置），不再放回池
+	discarded int32           // 为 1 表示被 Lease 的 Discard 丢弃，归还时被剔除（由 closeCoroutine 关闭）
+	disconnected int32        // 为 1 表示连接从 Ready 转为 Idle 或 Connecting，即断开后重连（通常是服务端发送了 GOAWAY，开启状态监视时由监视协程设置），不再放回池
+	state    int32            // 在池中的状态 ConnState，只能由池通过 setState 转换
 }
 
 // gRPC 连接池
 type GRPCPool struct {
+	// 以下 64 位字段使用原子操作，要求 8 字节对齐（32 位平台上只有结构体的第一个字保证对齐），因此放在最前面
+	accessTime int64 // 最近一次调用 Get 或 Put 的时间，通过它可以判定是否还活跃着
+	reapAt     int64 // releaseIdleCoroutine 下次被唤醒的时间（UnixNano），为 0 表示未在计时（只等 reapChan）
+	closeGrace int64 // 被剔除的连接的关闭宽限期（单位：纳秒，可调用成员函数 SetCloseGracePeriod 设置）
+
 	endpoint string         // 服务端的端点
 	peakSize int32          // 连接池中高峰连接数
 	idleSize int32          // 连接池较繁忙连接数
 	initSize int32          // 连接池初始连接数
-	used     int32          // 已用连接数
-	idle     int32          // 空闲连接数（即在 clients 中的连接数）
+	live     int32          // DIALING、LEASED 和 IDLE 状态的连接数，用于 peakSize 的限制（used、idle 和各状态的连接数见 connCounters）
 	idleTimeout int32       // 空闲连接超时时长（单位：秒，默认值 10，可调用成员函数 SetIdleTimeout 修改）
 	peakTimeout int32       // 高峰连接超时时长（单位：秒，默认值 1，可调用成员函数 SetPeakTimeout 修改，应不小于 idleTimeout 的值）
+	maxLifetime int32       // 连接最长存活时长（单位：秒，默认值 0 表示不限制，可调用成员函数 SetMaxLifetime 修改）
 	closed      int32       // 关闭池
-	accessTime  int64       // 最近一次调用 Get 或 Put 的时间，通过它可以判定是否还活跃着
-	wg sync.WaitGroup // 等待 releaseIdleCoroutine 退出
-	clients  chan *GRPCConn // gRPC 连接队列
+	wg sync.WaitGroup // 等待 releaseIdleCoroutine 等后台协程退出
+	wgMutex sync.Mutex // 使 Close 将 closed 置 1 与开启健康检查等启动后台协程时的 wg.Add 互斥（见 startCoroutine）
+	done chan struct{} // 关闭池时被 close，用于通知后台协程退出
+	doneCtx    context.Context    // 关闭池时被取消，用于使等待 ClientConn 状态变化的 watchCoroutine 退出
+	cancelDone context.CancelFunc // 取消 doneCtx
+	idleConns *idleList     // 空闲连接列表
+	selectPolicy int32      // 空闲连接的选择策略 SelectPolicy（默认为 SELECT_FIFO，可调用成员函数 SetSelectPolicy 修改）
 	dialOpts []grpc.DialOption
+	metricObserver atomic.Value // observerHolder，只作用于本池的度量数据观察者，为空或 nil 时使用 RegisterMetricObserver 注册的（可调用成员函数 SetMetricObserver 设置）
+	observerMutex  sync.Mutex   // 使 SwapMetricObserver、CompareAndSwapTracer 等的读取和替换一起完成（Get 和 Put 读取时不加锁）
+	tracer         atomic.Value // tracerHolder，跟踪器，为空或 nil 表示不跟踪（可调用成员函数 SetTracer 设置）
+	limiter  Limiter        // 自适应并发限制器，为 nil 表示不限制（可调用成员函数 SetLimiter 设置）
+	logger   Logger         // 只作用于本池的 Logger，为 nil 时使用 RegisterLogger 设置的（可调用成员函数 SetLogger 设置）
+	eventHook EventHook     // 连接生命周期事件的接收者，为 nil 表示不接收（可调用成员函数 SetEventHook 设置）
+	clock     atomic.Value  // clockHolder，为空时使用真实时钟（可调用成员函数 SetClock 设置）
+	clockChan chan struct{} // 调用 SetClock 时通知 releaseIdleCoroutine 改用新时钟
+	reapChan  chan struct{} // 空闲连接数超过 initSize 等需要重新计时的情况下唤醒 releaseIdleCoroutine
+	closeQueue    chan closingConn // 等待 closeCoroutine 关闭的连接
+	closeBacklog  int32            // 等待关闭的连接数
+	closerMutex   sync.RWMutex     // 保护 closerStopped，使池关闭后不再有连接入队
+	closerStopped bool             // 为 true 表示 closeCoroutine 不再从队列取连接
+	releaseIdleInterval time.Duration // 正忙时 releaseIdleCoroutine 推迟检查的间隔
+	healthOnce    sync.Once    // 保证健康检查只开启一次
+	healthChecker atomic.Value // *healthChecker，开启健康检查后非 nil（可调用成员函数 EnableHealthCheck 开启）
+	watchState    int32         // 为 1 表示开启了连接状态监视（可调用成员函数 EnableStateWatcher 开启）
+	brokenChan    chan struct{} // 有连接被标记为 broken 或 disconnected、或这种连接归还时通知 stateWatchCoroutine（见 notifyBroken）
+	replenishing  int32         // 为 1 表示正在补充新连接（见 replenish）
+	connsMutex    sync.Mutex              // 保护 conns
+	conns         map[*GRPCConn]struct{}  // 所有未关闭的连接（包括使用中的和空闲的）
+	dialErrorsMutex sync.Mutex              // 保护 dialErrors
+	dialErrors      []DialError             // 最近的拨号错误，最多 MAX_DIAL_ERRORS 个，按时间先后排列
 }
 
 // 方便 MetricObserver 使用
 type Metric struct {
+	// 耗时分布（64 位原子操作要求 8 字节对齐，因此放在最前面）
+	GetWait      Histogram // Get 的耗时（包含新拨号的耗时）
+	DialDuration Histogram // gRPC 拨号的耗时
+	HoldTime     Histogram // 连接在 Get 和 Put 之间的持有时长
+	CloseDuration Histogram // 被剔除的连接的 ClientConn.Close 耗时（见 CloseObserver）
+
 	Used int32 // 被使用连接数（不在池中数）
 	Idle int32 // 空闲数连接（在池中数）
+	CloseBacklog int32 // 等待关闭的连接数（见 closer.go）
 
 	DialRefused int32 // gRPC 拨号拒绝数
 	DialTimeout int32 // gRPC 拨号超时数
@@ -85,11 +147,16 @@ type Metric struct {
 
 	GetSuccess int32 // 取池成功数
 	GetEmpty int32 // 取池空数
+	GetLimited int32 // 取池被限流数（超出自适应并发限制）
 	PutSuccess int32 // 还池成功数
 	PutFull int32 // 还池满数
 	PutClose int32 // 还池已关闭连接数
 	PutOld int32 // 还池空闲数（长时间未使用的）
 	PutIdle int32 // 还池空闲数（近期未使用的）
+	PutMisuse int32 // 误用的还池数（重复归还或归还到其它池，见 MisuseError）
+	EvictHealth int32 // 因健康检查失败被剔除的连接数（见 EvictObserver）
+	EvictBroken int32 // 因进入过 TransientFailure 或 Shutdown 状态被剔除的连接数
+	EvictDisconnected int32 // 因连接断开（通常是 GOAWAY）被剔除的连接数
 }
 
 // 度量数据观察者，方便外部获取连接数等
@@ -114,6 +181,7 @@ type MetricObserver interface {
 }
 
 // 对接口 MetricObserver 的默认实现
+// 所有操作均为原子操作，不加锁
 type DefaultMetricObserver struct {
 	metric Metric
 }
@@ -131,12 +199,47 @@ func RegisterMetricObserver(mo MetricObserver) {
 
 var (
 	metricObserver MetricObserver
+	lastConnID     uint64 // 最近分配的连接标识
+
+	// 正忙时 releaseIdleCoroutine 推迟检查的间隔（按池的 Clock 计时），创建池时取用，测试时调大以免干扰
+	releaseIdleInterval = time.Second
 )
 
+// 跟踪器，用于对接 OpenTelemetry 等分布式跟踪系统（可调用成员函数 SetTracer 设置）
+type Tracer interface {
+	// 在 Get 开始时调用，ctx 为调用 Get 时传入的，返回的 context 用于拨号等后续操作，
+	// 返回的函数在 Get 结束时调用：dialed 为 true 表示连接是新拨号创建的，wait 为 Get 的耗时
+	TraceGet(ctx context.Context, endpoint string) (context.Context, func(dialed bool, wait time.Duration, errcode uint32, err error))
+	// 在拨号开始时调用，返回的 context 用于拨号，返回的函数在拨号结束时调用
+	TraceDial(ctx context.Context, endpoint string) (context.Context, func(err error))
+}
+
+// 耗时观察者，MetricObserver 的实现如果同时实现了本接口，则还会收到耗时数据
+type LatencyObserver interface {
+	ObserveGetWait(d time.Duration) // Get 的耗时（包含新拨号的耗时，只统计取到连接的）
+	ObserveDial(d time.Duration)    // gRPC 拨号的耗时（包括拨号失败的）
+	ObserveHold(d time.Duration)    // 连接在 Get 和 Put 之间的持有时长
+}
+
 // 创建 gRPC 连接池，总是返回非 nil 值，
 // 注意在使用完后，应调用连接池的成员函数 Destroy 释放创建连接池时所分配的资源
 // 如果不指定参数 dialOpts，则默认为 grpc.WithBlock() 和 grpc.WithInsecure()。
 func NewGRPCPool(endpoint string, initSize, idleSize, peakSize int32, dialOpts ...grpc.DialOption) *GRPCPool {
+	return newGRPCPool(endpoint, 1, initSize, idleSize, peakSize, dialOpts...)
+}
+
+// 创建分片的 gRPC 连接池，参数 shards 为空闲连接列表的分片数，不大于 0 时取 runtime.GOMAXPROCS(0)，
+// 其余参数和用法同 NewGRPCPool。
+// 分片的池在多核下 Get 和 Put 的锁竞争更小，适合核数多且并发高的场景，
+// 选择策略只在分片内生效，used、idle 和各状态的连接数也按分片分组计数，但 initSize、idleSize、peakSize 等限制仍是全局的。
+func NewShardedGRPCPool(endpoint string, shards int, initSize, idleSize, peakSize int32, dialOpts ...grpc.DialOption) *GRPCPool {
+	if shards < 1 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+	return newGRPCPool(endpoint, shards, initSize, idleSize, peakSize, dialOpts...)
+}
+
+func newGRPCPool(endpoint string, shards int, initSize, idleSize, peakSize int32, dialOpts ...grpc.DialOption) *GRPCPool {
 	grpcPool := new(GRPCPool)
 	grpcPool.endpoint = endpoint
 	if initSize < 1 {
@@ -152,12 +255,18 @@ func NewGRPCPool(endpoint string, initSize, idleSize, peakSize int32, dialOpts .
 	if grpcPool.peakSize < grpcPool.idleSize {
 		grpcPool.peakSize = grpcPool.idleSize
 	}
-	grpcPool.used = 0
-	grpcPool.idle = 0
 	grpcPool.idleTimeout = 10
 	grpcPool.peakTimeout = 2
 	grpcPool.closed = 0
-	grpcPool.clients = make(chan *GRPCConn, grpcPool.peakSize) // 在成员函数 Destroy 中释放
+	grpcPool.idleConns = newIdleList(int(grpcPool.peakSize), shards) // 在成员函数 Close 中释放
+	grpcPool.done = make(chan struct{})
+	grpcPool.doneCtx, grpcPool.cancelDone = context.WithCancel(context.Background())
+	grpcPool.brokenChan = make(chan struct{}, 1)
+	grpcPool.clockChan = make(chan struct{}, 1)
+	grpcPool.reapChan = make(chan struct{}, 1)
+	grpcPool.closeQueue = make(chan closingConn, CLOSE_QUEUE_SIZE)
+	grpcPool.releaseIdleInterval = releaseIdleInterval
+	grpcPool.conns = make(map[*GRPCConn]struct{})
 	grpcPool.dialOpts = make([]grpc.DialOption, len(dialOpts))
 	if len(dialOpts) > 0 {
 		grpcPool.dialOpts = dialOpts
@@ -173,15 +282,29 @@ func NewGRPCPool(endpoint string, initSize, idleSize, peakSize int32, dialOpts .
 		//grpcPool.dialOpts = append(grpcPool.dialOpts, grpc.WithBlock())
 		grpcPool.dialOpts = append(grpcPool.dialOpts, grpc.WithInsecure())
 	}
-	grpcPool.wg.Add(1)
+	grpcPool.wg.Add(2)
 	go grpcPool.releaseIdleCoroutine()
+	go grpcPool.closeCoroutine()
+	registerPool(grpcPool)
 	return grpcPool
 }
 
+func (this *GRPCPool) GetEndpoint() string {
+	return this.endpoint
+}
+
 func (this *GRPCPool) GetAccessTime() int64 {
 	return atomic.LoadInt64(&this.accessTime)
 }
 
+// 更新最近一次调用 Get 或 Put 的时间为 now，按秒计，同一秒内只写一次，以免多核下每次 Get 和 Put 都争用同一缓存行
+func (this *GRPCPool) touch(now time.Time) {
+	accessTime := now.Unix()
+	if atomic.LoadInt64(&this.accessTime) != accessTime {
+		atomic.StoreInt64(&this.accessTime, accessTime)
+	}
+}
+
 func (this *GRPCPool) SetIdleTimeout(timeout int32) {
 	if timeout < 1 {
 		this.idleTimeout = 1
@@ -198,276 +321,694 @@ func (this *GRPCPool) SetPeakTimeout(timeout int32) {
 	}
 }
 
+// 设置连接的最长存活时长（单位：秒），超过的连接在归还时被关闭，返回错误代码 CONN_EXPIRED，
+// 用于配合服务端的 MaxConnectionAge 或使连接定期重新解析地址，0 表示不限制
+func (this *GRPCPool) SetMaxLifetime(lifetime int32) {
+	if lifetime < 0 {
+		this.maxLifetime = 0
+	} else {
+		this.maxLifetime = lifetime
+	}
+}
+
+// atomic.Value 要求存入的值类型一致，因此包一层
+type observerHolder struct {
+	mo MetricObserver
+}
+
+// 设置只作用于本池的度量数据观察者，设置后本池不再使用 RegisterMetricObserver 注册的，
+// 传 nil 则恢复使用 RegisterMetricObserver 注册的。
+// 可在连接池使用中调用，但替换前后的观察者各自只收到部分计数（如 used 的增减不配对），
+// 因此最好在连接池投入使用前调用，或像 grpcpoolprom 的 Collector 那样包装原来的观察者。
+func (this *GRPCPool) SetMetricObserver(mo MetricObserver) {
+	this.SwapMetricObserver(mo)
+}
+
+// 设置只作用于本池的度量数据观察者（同 SetMetricObserver），返回原来用 SetMetricObserver 设置的，未设置过时为 nil，
+// 供包装池的观察者后再恢复的场景使用（如 grpcpoolprom 的 Collector）
+func (this *GRPCPool) SwapMetricObserver(mo MetricObserver) MetricObserver {
+	this.observerMutex.Lock()
+	defer this.observerMutex.Unlock()
+	old := this.ownMetricObserver()
+	this.metricObserver.Store(observerHolder{mo})
+	return old
+}
+
+// 只作用于本池的度量数据观察者仍为 old 时替换为 mo 并返回 true，否则不替换并返回 false，
+// 供包装池的观察者后再恢复的场景使用：之后又有其它观察者包装在外层时（如 grpcpoolotel 的 Instrumentation），不会把它们一并去掉。
+// old 和 mo 应为可比较的类型（如指针）
+func (this *GRPCPool) CompareAndSwapMetricObserver(old, mo MetricObserver) bool {
+	this.observerMutex.Lock()
+	defer this.observerMutex.Unlock()
+	if this.ownMetricObserver() != old {
+		return false
+	}
+	this.metricObserver.Store(observerHolder{mo})
+	return true
+}
+
+// 取得用 SetMetricObserver 设置的观察者，未设置过时为 nil
+func (this *GRPCPool) ownMetricObserver() MetricObserver {
+	if holder, ok := this.metricObserver.Load().(observerHolder); ok {
+		return holder.mo
+	}
+	return nil
+}
+
+// 取得本池实际使用的度量数据观察者，可能为 nil
+func (this *GRPCPool) GetMetricObserver() MetricObserver {
+	if mo := this.ownMetricObserver(); mo != nil {
+		return mo
+	}
+	return metricObserver
+}
+
+// atomic.Value 要求存入的值类型一致，因此包一层
+type tracerHolder struct {
+	tracer Tracer
+}
+
+// 设置跟踪器，传 nil 表示不跟踪，可在连接池使用中调用（之后的 Get 和拨号才被跟踪）
+func (this *GRPCPool) SetTracer(tracer Tracer) {
+	this.observerMutex.Lock()
+	defer this.observerMutex.Unlock()
+	this.tracer.Store(tracerHolder{tracer})
+}
+
+// 跟踪器仍为 old 时替换为 tracer 并返回 true，否则不替换并返回 false，
+// 供包装或接入后再恢复的场景使用（如 grpcpoolotel 的 Instrumentation），old 和 tracer 应为可比较的类型（如指针）
+func (this *GRPCPool) CompareAndSwapTracer(old, tracer Tracer) bool {
+	this.observerMutex.Lock()
+	defer this.observerMutex.Unlock()
+	if this.GetTracer() != old {
+		return false
+	}
+	this.tracer.Store(tracerHolder{tracer})
+	return true
+}
+
+// 取得跟踪器，未设置时为 nil
+func (this *GRPCPool) GetTracer() Tracer {
+	if holder, ok := this.tracer.Load().(tracerHolder); ok {
+		return holder.tracer
+	}
+	return nil
+}
+
+// 设置自适应并发限制器，传 nil 表示取消限制，
+// 应在连接池投入使用前调用。
+// 设置后 Get 超出限制时返回错误代码 POOL_LIMITED，
+// Put 时将本次使用中 Invoke 测得的或 GRPCConn 的 ReportRTT 报告的 RPC 耗时反馈给限制器，
+// 归还时连接已被关闭（即调用出错的约定用法）视为一次出错，
+// 二者都没有的只归还许可，不作为样本（见 limiter.go）。
+func (this *GRPCPool) SetLimiter(limiter Limiter) {
+	this.limiter = limiter
+}
+
+func (this *GRPCPool) GetLimiter() Limiter {
+	return this.limiter
+}
+
+// 取得连接的唯一标识，进程内从 1 开始递增，日志和调试接口中的 conn 即为它
+func (this *GRPCConn) GetID() uint64 {
+	return this.id
+}
+
 func (this *GRPCConn) GetEndpoint() string {
 	return this.endpoint
 }
 
+// 取得连接的创建时间
+func (this *GRPCConn) GetCreateTime() time.Time {
+	return this.ctime
+}
+
+// 取得连接最近一次被归还的时间，未被归还过时为创建时间
+func (this *GRPCConn) GetUseTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&this.utime))
+}
+
+// 取得连接被 Get 取走的次数
+func (this *GRPCConn) GetBorrowCount() int64 {
+	return atomic.LoadInt64(&this.borrows)
+}
+
+// 取得连接使用中出错的次数
+func (this *GRPCConn) GetErrorCount() int64 {
+	return atomic.LoadInt64(&this.errors)
+}
+
+// 出错次数增一，Invoke 出错时会自动调用，不使用 Invoke 时可在调用出错后自行调用
+func (this *GRPCConn) IncErrorCount() int64 {
+	return atomic.AddInt64(&this.errors, 1)
+}
+
+// 取得本次被取走的序号（即取走后 GetBorrowCount 的值），在池中时为 0，
+// 应在 Get 后立即取得并保存，归还时传给 PutGeneration
+func (this *GRPCConn) GetGeneration() int64 {
+	return atomic.LoadInt64(&this.generation)
+}
+
+// 报告本次使用中一次 RPC 的耗时，Put 时作为样本反馈给池的 Limiter（见 SetLimiter），多次报告时以最后一次为准。
+// Invoke 会自动报告，不使用 Invoke 而自行调用 RPC 时，应在调用后报告
+func (this *GRPCConn) ReportRTT(rtt time.Duration) {
+	if rtt <= 0 {
+		// 0 表示未报告
+		rtt = 1
+	}
+	atomic.StoreInt64(&this.rtt, int64(rtt))
+}
+
+// 取得连接的累计持有时长（在 Get 和 Put 之间的时长之和，不包含当前这次）
+func (this *GRPCConn) GetHoldTime() time.Duration {
+	return time.Duration(atomic.LoadInt64(&this.holdTime))
+}
+
 func (this *GRPCConn) GetClient() *grpc.ClientConn {
 	return this.client
 }
 
+// 关闭连接，可多次和并发调用，只有第一次调用会关闭 gRPC 连接并返回其错误，之后的返回 nil
 func (this *GRPCConn) Close() error {
-	if this.closed {
+	if !atomic.CompareAndSwapInt32(&this.closed, 0, 1) {
 		return nil
-	} else {
-		this.closed = true
-		client := this.GetClient()
-		return client.Close()
 	}
+	return this.GetClient().Close()
 }
 
 func (this *GRPCConn) IsClosed() bool {
-	return this.closed
+	return atomic.LoadInt32(&this.closed) == 1
+}
+
+// 是否进入过 TransientFailure 或 Shutdown 状态（仅开启了连接状态监视时有效）
+func (this *GRPCConn) IsBroken() bool {
+	return atomic.LoadInt32(&this.broken) == 1
+}
+
+// 是否从 Ready 转为过 Idle 或 Connecting，即断开后重连过，通常是服务端发送了 GOAWAY（仅开启了连接状态监视时有效）
+func (this *GRPCConn) IsDisconnected() bool {
+	return atomic.LoadInt32(&this.disconnected) == 1
 }
 
 // 关闭连接池（释放资源）
 func (this *GRPCPool) Close() {
+	this.wgMutex.Lock()
 	swapped := atomic.CompareAndSwapInt32(&this.closed, 0, 1)
+	this.wgMutex.Unlock()
 	if swapped {
-		closed := false
-
-	LOOP: for {
-		select {
-		case conn := <-this.clients:
-			if conn == nil {
-				break LOOP
-			}
-			conn.Close()
-		default:
-			break LOOP
+		unregisterPool(this)
+		close(this.done)
+		this.cancelDone()
+		idle, used := this.GetIdle(), this.GetUsed()
+		this.logInfo("grpcpool closing", "idle", idle, "used", used)
+		if used > 0 {
+			// 未归还的连接在 Put 时被关闭，如果一直不归还则泄漏
+			this.logWarn("grpcpool closing with connections not returned", "used", used)
 		}
-	}
-		if !closed {
-			close(this.clients)
-			closed = true
-			goto LOOP
+
+		// 关闭列表后，Put 不能再放回连接
+		for _, conn := range this.idleConns.close() {
+			this.evict(conn, STATE_IDLE, EVICT_POOL_CLOSED)
 		}
 
-		this.clients = nil
+		this.logInfo("grpcpool idle connections closed", "closed", idle)
 	}
 
-	// 等待 releaseIdleCoroutine 退出
+	// 等待 releaseIdleCoroutine 等后台协程退出
 	this.wg.Wait()
+	if swapped {
+		this.logInfo("grpcpool closed")
+		if hook := this.eventHook; hook != nil {
+			hook.OnClose(this)
+		}
+	}
+}
+
+// 在池未关闭时启动后台协程 f（f 退出时应调用 this.wg.Done()），返回是否已启动。
+// 检查 closed 和 wg.Add 在 wgMutex 保护下进行，因此 Close 的 wg.Wait 不会漏掉并发启动的协程
+func (this *GRPCPool) startCoroutine(f func()) bool {
+	this.wgMutex.Lock()
+	defer this.wgMutex.Unlock()
+	if atomic.LoadInt32(&this.closed) == 1 {
+		return false
+	}
+	this.wg.Add(1)
+	go f()
+	return true
 }
 
 // 从连接池取一个连接，
-// 应和 Put 一对一成对调用
+// 应和 Put 一对一成对调用（推荐使用 GetLease，见 lease.go）
 // 返回三个值：
 // 1) GRPCConn 指针
 // 2) 错误代码
 // 3) 错误信息
 func (this *GRPCPool) Get(ctx context.Context) (*GRPCConn, uint32, error) {
-	return this.get(ctx, false)
+	tracer := this.GetTracer()
+	if tracer == nil {
+		conn, _, errcode, err := this.acquire(ctx)
+		return conn, errcode, err
+	}
+
+	start := time.Now()
+	ctx, end := tracer.TraceGet(ctx, this.endpoint)
+	conn, dialed, errcode, err := this.acquire(ctx)
+	end(dialed, time.Since(start), errcode, err)
+	return conn, errcode, err
 }
 
-func (this *GRPCPool) get(ctx context.Context, doNotNew bool) (*GRPCConn, uint32, error) {
-	accessTime := time.Now().Unix()
-	atomic.StoreInt64(&this.accessTime, accessTime)
-	used1 := this.addUsed()
+// 经 Limiter 许可后从池中取一个连接，
+// 返回值比 Get 多一个 dialed，为 true 表示连接是新拨号创建的。
+func (this *GRPCPool) acquire(ctx context.Context) (*GRPCConn, bool, uint32, error) {
+	limiter := this.limiter
+	if limiter == nil {
+		return this.get(ctx)
+	}
+	if !limiter.Acquire() {
+		if lo, ok := this.GetMetricObserver().(LimitObserver); ok {
+			lo.IncGetLimited()
+		}
+		return nil, false, POOL_LIMITED, errors.New(fmt.Sprintf("pool for %s is limited (inflight:%d, limit:%d)", this.endpoint, limiter.GetInflight(), limiter.GetLimit()))
+	}
 
-	select {
-	case conn := <-this.clients:
-		this.subIdle()
-		if metricObserver != nil {
-			metricObserver.IncGetSuccess()
+	conn, dialed, errcode, err := this.get(ctx)
+	if conn == nil {
+		if errcode == POOL_EMPTY || errcode == POOL_CLOSED {
+			// 池空与服务端负载无关，不作为样本
+			limiter.Cancel()
+		} else {
+			// 拨号失败
+			limiter.Release(0, true)
 		}
-		return conn, SUCCESS, nil
-	default:
-		if doNotNew {
-			return nil, SUCCESS, nil
+		return conn, dialed, errcode, err
+	}
+	conn.limiter = limiter
+	atomic.StoreInt32(&conn.failed, 0)
+	atomic.StoreInt64(&conn.rtt, 0)
+	return conn, dialed, errcode, err
+}
+
+// 记录连接在 now 被取走：取走时间和本次取走的序号
+func lend(conn *GRPCConn, now time.Time) {
+	atomic.StoreInt64(&conn.btime, now.UnixNano())
+	atomic.StoreInt64(&conn.generation, atomic.AddInt64(&conn.borrows, 1))
+}
+
+// 取得 Get 的耗时并通知耗时观察者和事件接收者，都没有时不读时钟，start 为 Get 开始的（真实）时间
+func (this *GRPCPool) observeBorrow(mo MetricObserver, conn *GRPCConn, start time.Time, dialed bool) {
+	lo, _ := mo.(LatencyObserver)
+	hook := this.eventHook
+	if lo == nil && hook == nil {
+		return
+	}
+	wait := time.Since(start)
+	if lo != nil {
+		lo.ObserveGetWait(wait)
+	}
+	if hook != nil {
+		hook.OnBorrow(conn, wait, dialed)
+	}
+}
+
+// 使用连接池中的连接执行一次一元 RPC 调用，
+// 调用耗时（不含 Get 和 Put）和是否出错会反馈给 Limiter（如果设置了的话）。
+// 返回 Get 的错误代码（调用本身出错时为 GRPC_ERROR）和错误信息。
+func (this *GRPCPool) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) (uint32, error) {
+	conn, errcode, err := this.Get(ctx)
+	if err != nil {
+		return errcode, err
+	}
+
+	start := time.Now()
+	err = conn.client.Invoke(ctx, method, args, reply, opts...)
+	conn.ReportRTT(time.Since(start))
+	if err != nil {
+		conn.IncErrorCount()
+		switch status.Code(err) {
+		case codes.Unavailable:
+			// 连接已不可用，不再放回池
+			atomic.StoreInt32(&conn.failed, 1)
+			conn.Close()
+		case codes.DeadlineExceeded, codes.ResourceExhausted:
+			// 服务端过载的信号
+			atomic.StoreInt32(&conn.failed, 1)
+		}
+		this.Put(conn)
+		return GRPC_ERROR, err
+	}
+	this.Put(conn)
+	return SUCCESS, nil
+}
+
+// 取一个连接并记录它被取走（见 lend），每次只读一次时钟（新拨号的和有耗时观察者的除外）
+func (this *GRPCPool) get(ctx context.Context) (*GRPCConn, bool, uint32, error) {
+	now, start := this.nows()
+	this.touch(now)
+	if atomic.LoadInt32(&this.closed) == 1 {
+		return nil, false, POOL_CLOSED, errors.New(fmt.Sprintf("pool for %s is closed", this.endpoint))
+	}
+
+	conn, closed := this.idleConns.pop(this.GetSelectPolicy())
+	if conn != nil {
+		// 在列表中的都是 IDLE 状态的，取出后只有本协程能转换它
+		this.setState(conn, STATE_IDLE, STATE_LEASED)
+		lend(conn, start)
+		mo := this.GetMetricObserver()
+		if mo != nil {
+			mo.IncGetSuccess()
+		}
+		this.observeBorrow(mo, conn, start, false)
+		return conn, false, SUCCESS, nil
+	}
+	if closed {
+		return nil, false, POOL_CLOSED, errors.New(fmt.Sprintf("pool for %s is closed", this.endpoint))
+	}
+
+	// 没有空闲的才新建，连接数先增一占住名额，超出 peakSize 时放弃
+	conn, live := this.newConn()
+	if live > this.GetPeakSize() {
+		this.setState(conn, STATE_DIALING, STATE_CLOSED)
+		used2 := this.GetUsed()
+		if mo := this.GetMetricObserver(); mo != nil {
+			mo.IncGetEmpty()
 		}
-		if used1 > this.GetPeakSize() {
-			used2 := this.subUsed()
-			if metricObserver != nil {
-				metricObserver.IncGetEmpty()
+		return nil, false, POOL_EMPTY, errors.New(fmt.Sprintf("pool for %s is empty (conns:%d, used:%d, init:%d, idle:%d, peak:%d)", this.endpoint, live, used2, this.GetInitSize(), this.GetIdleSize(), this.GetPeakSize()))
+	} else {
+		errcode, err := this.dial(ctx, conn)
+		if err != nil {
+			this.setState(conn, STATE_DIALING, STATE_CLOSED)
+			used2 := this.GetUsed()
+			return nil, false, errcode, errors.New(fmt.Sprintf("gRPC connect %s failed (used:%d, init:%d, idle:%d, peak:%d, %s)", this.endpoint, used2, this.GetInitSize(), this.GetIdleSize(), this.GetPeakSize(), err.Error()))
+		}
+		this.setState(conn, STATE_DIALING, STATE_LEASED)
+		// 持有时长不包括拨号的耗时
+		lend(conn, time.Now())
+		this.observeBorrow(this.GetMetricObserver(), conn, start, true)
+		return conn, true, SUCCESS, nil
+	}
+}
+
+// 为 newConn 创建的 DIALING 状态的连接拨号，返回错误代码和 gRPC 的原始错误信息，
+// 状态由调用者根据结果转换
+func (this *GRPCPool) dial(ctx context.Context, conn *GRPCConn) (uint32, error) {
+	// 常见错误：
+	// 1) transport: Error while dialing dial tcp 127.0.0.1:3121: connect: connection refused
+	// 2) gRPC connect 127.0.0.1:3121 failed (context deadline exceeded)
+	var traceEnd func(err error)
+	if tracer := this.GetTracer(); tracer != nil {
+		ctx, traceEnd = tracer.TraceDial(ctx, this.endpoint)
+	}
+	start := time.Now()
+	client, err := grpc.DialContext(ctx, this.endpoint, this.dialOpts[0:]...)
+	if lo, ok := this.GetMetricObserver().(LatencyObserver); ok {
+		lo.ObserveDial(time.Since(start))
+	}
+	if traceEnd != nil {
+		traceEnd(err)
+	}
+	if err != nil {
+		errcode := dialErrcode(ctx, err)
+		if errcode == CONN_UNAVAILABLE {
+			if mo := this.GetMetricObserver(); mo != nil {
+				mo.IncDialRefused()
+			}
+		} else if errcode == CONN_DEADLINE_EXCEEDED {
+			if mo := this.GetMetricObserver(); mo != nil {
+				mo.IncDialTimeout()
 			}
-			return nil, POOL_EMPTY, errors.New(fmt.Sprintf("pool for %s is empty (used:%d/%d, init:%d, idle:%d, peak:%d)", this.endpoint, used1, used2, this.GetInitSize(), this.GetIdleSize(), this.GetPeakSize()))
 		} else {
-			var err error
-			var client *grpc.ClientConn
-
-			// 常见错误：
-			// 1) transport: Error while dialing dial tcp 127.0.0.1:3121: connect: connection refused
-			// 2) gRPC connect 127.0.0.1:3121 failed (context deadline exceeded)
-			client, err = grpc.DialContext(ctx, this.endpoint, this.dialOpts[0:]...)
-			if err != nil {
-				var errcode uint32
-				errInfo, _ := status.FromError(err)
-				if errInfo.Code() == codes.Unavailable {
-					errcode = CONN_UNAVAILABLE
-					if metricObserver != nil {
-						metricObserver.IncDialRefused()
-					}
-				} else if errInfo.Code() == codes.DeadlineExceeded {
-					errcode = CONN_DEADLINE_EXCEEDED
-					if metricObserver != nil {
-						metricObserver.IncDialTimeout()
-					}
-				} else {
-					errcode = GRPC_ERROR
-					if metricObserver != nil {
-						metricObserver.IncDialError()
-					}
-				}
-				used2 := this.subUsed()
-				return nil, errcode, errors.New(fmt.Sprintf("gRPC connect %s failed (used:%d, init:%d, idle:%d, peak:%d, %s)", this.endpoint, used2, this.GetInitSize(), this.GetIdleSize(), this.GetPeakSize(), err.Error()))
-			} else {
-				conn := new(GRPCConn)
-				conn.endpoint = this.endpoint
-				conn.closed = false
-				conn.client = client
-				conn.utime = time.Now()
-				if metricObserver != nil {
-					metricObserver.IncDialSuccess()
-				}
-				return conn, SUCCESS, nil
+			if mo := this.GetMetricObserver(); mo != nil {
+				mo.IncDialError()
 			}
 		}
+		this.addDialError(errcode, err)
+		this.logWarn("grpcpool dial failed", "errcode", errcode, "elapsed", time.Since(start), "error", err)
+		if hook := this.eventHook; hook != nil {
+			hook.OnDial(nil, time.Since(start), err)
+		}
+		return errcode, err
+	}
+
+	conn.client = client
+	conn.ctime = this.now()
+	conn.utime = conn.ctime.UnixNano()
+	this.rememberConn(conn)
+	if mo := this.GetMetricObserver(); mo != nil {
+		mo.IncDialSuccess()
+	}
+	if atomic.LoadInt32(&this.watchState) == 1 {
+		state := client.GetState()
+		this.startCoroutine(func() {
+			this.watchCoroutine(conn, state)
+		})
+	}
+	this.logDebug("grpcpool dialed", "conn", conn.id, "elapsed", time.Since(start))
+	if hook := this.eventHook; hook != nil {
+		hook.OnDial(conn, time.Since(start), nil)
+	}
+	return SUCCESS, nil
+}
+
+// 对拨号错误分类，返回 CONN_UNAVAILABLE、CONN_DEADLINE_EXCEEDED 或 GRPC_ERROR，
+// grpc.DialContext 返回的通常不是 status 错误：超时为 context.DeadlineExceeded，
+// 带 grpc.FailOnNonTempDialError(true) 时连接被拒绝等为 transport 的 ConnectionError
+func dialErrcode(ctx context.Context, err error) uint32 {
+	if errInfo, ok := status.FromError(err); ok {
+		switch errInfo.Code() {
+		case codes.Unavailable:
+			return CONN_UNAVAILABLE
+		case codes.DeadlineExceeded:
+			return CONN_DEADLINE_EXCEEDED
+		}
+		return GRPC_ERROR
+	}
+	if err == context.DeadlineExceeded || ctx.Err() == context.DeadlineExceeded {
+		return CONN_DEADLINE_EXCEEDED
+	}
+	if _, ok := err.(interface{ Origin() error }); ok {
+		return CONN_UNAVAILABLE
 	}
+	return GRPC_ERROR
 }
 
-// 连接用完后归还回池，应和 Get 一对一成对调用
+// 连接用完后归还回池，应和 Get 一对一成对调用，
+// 重复归还或归还到其它池时返回 CONN_MISUSED 和 *MisuseError（见 misuse.go）。
+// 注意：Put 无法识别过期的归还，连接已被归还又被其它协程取走时，对它的重复 Put 会把别人正在用的连接放回池。
+// 新代码应使用 GetLease 和 Lease 的 Release、Discard，它们能识别这种误用；Put 和 PutGeneration 为兼容保留
 // 约束：同一 conn 不应同时被多个协程使用
 func (this *GRPCPool) Put(conn *GRPCConn) (uint, error) {
-	return this.put(conn, false)
+	if conn.pool != this {
+		return this.misuse(conn, ErrForeignPut)
+	}
+	// generation 非 0 表示被取走，交换为 0 后同一次取走只有一个 Put 能通过
+	if atomic.SwapInt64(&conn.generation, 0) == 0 {
+		return this.misuse(conn, ErrDoublePut)
+	}
+	return this.putLeased(conn)
 }
 
-func (this *GRPCPool) put(conn *GRPCConn, doNotTouch bool) (uint, error) {
-	accessTime := time.Now().Unix()
-	atomic.StoreInt64(&this.accessTime, accessTime)
-	defer func() {
-		if err := recover(); err != nil {
-			conn.Close()
-			this.subIdle()
+// 归还第 generation 次被取走的连接（generation 为取走后 conn.GetGeneration() 的值），其它同 Put。
+// 连接已被归还又被其它协程取走时，Put 无法识别重复归还，会把别人正在用的连接放回池；
+// 本函数则拒绝这种过期的归还，返回 CONN_MISUSED 和 ErrStalePut。
+// Lease 的 Release 和 Discard 即使用本函数，应优先使用 Lease，不必自行保存 generation
+func (this *GRPCPool) PutGeneration(conn *GRPCConn, generation int64) (uint, error) {
+	if conn.pool != this {
+		return this.misuse(conn, ErrForeignPut)
+	}
+	if generation == 0 || !atomic.CompareAndSwapInt64(&conn.generation, generation, 0) {
+		if generation != 0 && generation != conn.GetBorrowCount() {
+			// 之后又被取走过
+			return this.misuse(conn, ErrStalePut)
+		}
+		return this.misuse(conn, ErrDoublePut)
+	}
+	return this.putLeased(conn)
+}
+
+// 通过了 generation 检查的归还：反馈持有时长和 RPC 耗时后放回池，每次只读一次时钟
+func (this *GRPCPool) putLeased(conn *GRPCConn) (uint, error) {
+	now, end := this.nows()
+	if btime := atomic.SwapInt64(&conn.btime, 0); btime != 0 {
+		hold := time.Duration(end.UnixNano() - btime)
+		atomic.AddInt64(&conn.holdTime, int64(hold))
+		if lo, ok := this.GetMetricObserver().(LatencyObserver); ok {
+			lo.ObserveHold(hold)
+		}
+		if conn.limiter != nil {
+			limiter := conn.limiter
+			conn.limiter = nil
+			failed := atomic.LoadInt32(&conn.failed) == 1 || conn.IsClosed()
+			if rtt := atomic.SwapInt64(&conn.rtt, 0); rtt != 0 || failed {
+				limiter.Release(time.Duration(rtt), failed)
+			} else {
+				// 持有时长可能包含与 RPC 无关的工作，不能反映服务端的负载，未报告 RPC 耗时的不作为样本
+				limiter.Cancel()
+			}
 		}
-	}()
+		if hook := this.eventHook; hook != nil {
+			hook.OnReturn(conn, hold)
+		}
+	}
+	return this.put(conn, now)
+}
+
+// 放回池，now 为归还的时间
+func (this *GRPCPool) put(conn *GRPCConn, now time.Time) (uint, error) {
+	this.touch(now)
 
-	used := this.subUsed()
+	if conn.GetPoolState() != STATE_LEASED {
+		return this.misuse(conn, ErrDoublePut)
+	}
 	closed := atomic.LoadInt32(&this.closed)
 	if closed == 1 {
-		if !conn.IsClosed() {
-			conn.Close()
+		if reason, ok := closedReason(conn); ok {
+			this.evict(conn, STATE_LEASED, reason)
+		} else {
+			this.evict(conn, STATE_LEASED, EVICT_POOL_CLOSED)
 		}
 		return SUCCESS, nil
 	}
-	if conn.IsClosed() {
-		// 已关闭的不再放回池
-		if metricObserver != nil {
-			metricObserver.IncPutClose()
+	reason, ok := closedReason(conn)
+	if !ok && conn.IsBroken() {
+		reason, ok = EVICT_BROKEN, true
+	}
+	if ok {
+		// 已关闭的、被丢弃的和状态异常的不再放回池
+		this.evict(conn, STATE_LEASED, reason)
+		if reason == EVICT_BROKEN {
+			// 由 stateWatchCoroutine 补充新连接，计数见 EvictObserver
+			this.notifyBroken()
+		} else if mo := this.GetMetricObserver(); mo != nil {
+			mo.IncPutClose()
 		}
 		return CONN_CLOSED, nil
 	} else {
-		idle := this.addIdle()
-		utime := conn.utime.Unix()
-		if !doNotTouch {
-			conn.utime = time.Now()
-		}
-
-		if idle > this.GetInitSize() {
-			now := time.Now().Unix()
-
-			if now > utime {
-				itime := now - utime // idle time
-				if itime > int64(this.idleTimeout) {
-					conn.Close()
-					this.subIdle()
-					if metricObserver != nil {
-						metricObserver.IncPutOld()
-					}
-					return POOL_IDLE, nil
-				}
-				if idle > this.GetIdleSize() {
-					if itime > int64(this.peakTimeout) {
-						conn.Close()
-						this.subIdle()
-						if metricObserver != nil {
-							metricObserver.IncPutIdle()
-						}
-						return POOL_IDLE, nil
-					}
+		if this.lifetimeExceeded(conn, now) || conn.IsDisconnected() {
+			// 服务端的 MaxConnectionAge 相当于服务端设置的 maxLifetime
+			if conn.IsDisconnected() {
+				// 计数见 EvictObserver
+				this.evict(conn, STATE_LEASED, EVICT_DISCONNECTED)
+				this.notifyBroken()
+			} else {
+				this.evict(conn, STATE_LEASED, EVICT_LIFETIME)
+				if mo := this.GetMetricObserver(); mo != nil {
+					mo.IncPutOld()
 				}
 			}
+			return CONN_EXPIRED, nil
+		}
+
+		utime := atomic.LoadInt64(&conn.utime) / int64(time.Second)
+		atomic.StoreInt64(&conn.utime, now.UnixNano())
+		if !this.setState(conn, STATE_LEASED, STATE_IDLE) {
+			// 并发的 Put 先转换了
+			return this.misuse(conn, ErrDoublePut)
+		}
+		idle := this.GetIdle()
+		if reason, ok := this.idleExpired(idle, utime, now); ok {
+			this.evictIdle(conn, reason)
+			return POOL_IDLE, nil
 		}
-		select {
-		case this.clients <- conn: // 放回连接池，如果 clients 已 closed 则会 panic。
-			if metricObserver != nil {
-				metricObserver.IncPutSuccess()
+		switch this.pushIdle(conn) {
+		case SUCCESS:
+			this.kickReaper(idle)
+			if mo := this.GetMetricObserver(); mo != nil {
+				mo.IncPutSuccess()
 			}
 			return SUCCESS, nil
+		case POOL_CLOSED:
+			// Close 在上面的检查之后关闭了列表
+			return SUCCESS, nil
 		default:
-			conn.Close()
-			this.subIdle()
-			if metricObserver != nil {
-				metricObserver.IncPutFull()
+			if mo := this.GetMetricObserver(); mo != nil {
+				mo.IncPutFull()
 			}
-			return POOL_FULL, errors.New(fmt.Sprintf("pool for %s is full(used:%d, init:%d, idle:%d, peak:%d)", this.endpoint, used, this.GetInitSize(), this.GetIdleSize(), this.GetPeakSize()))
+			return POOL_FULL, errors.New(fmt.Sprintf("pool for %s is full(used:%d, init:%d, idle:%d, peak:%d)", this.endpoint, this.GetUsed(), this.GetInitSize(), this.GetIdleSize(), this.GetPeakSize()))
 		}
 	}
 }
 
-func (this *GRPCPool) releaseIdleCoroutine() {
-	for {
-		closed := atomic.LoadInt32(&this.closed)
-		if closed == 1 {
-			break
-		}
+// 连接的存活时长到 now 时是否超过了 maxLifetime
+func (this *GRPCPool) lifetimeExceeded(conn *GRPCConn, now time.Time) bool {
+	return this.maxLifetime > 0 && now.Sub(conn.ctime) > time.Duration(this.maxLifetime)*time.Second
+}
 
-		time.Sleep(time.Duration(1)*time.Second)
-		initSize := this.GetInitSize()
-		idleSize := this.GetIdle()
-		usedSize := this.GetUsed()
-		// 大量在使用时，表明正忙着
-		if idleSize > initSize && usedSize < idleSize {
-			for i:=0; i<int(idleSize); i++ {
-				conn, _, _ := this.get(context.Background(), true)
-				if conn == nil {
-					break
-				}
-				errcode, _ := this.put(conn, true)
-				if errcode != POOL_IDLE {
-					break
+// 判断空闲连接到 now 时是否因空闲太久应被剔除，idle 为包括它在内的空闲连接数，utime 为它此前的最近使用时间（单位：秒），
+// 超过 initSize 的空闲超过 idleTimeout 的、超过 idleSize 的空闲超过 peakTimeout 的应被剔除
+func (this *GRPCPool) idleExpired(idle int32, utime int64, now time.Time) (EvictReason, bool) {
+	if idle > this.GetInitSize() {
+		now := now.Unix()
+
+		if now > utime {
+			itime := now - utime // idle time
+			if itime > int64(this.idleTimeout) {
+				return EVICT_IDLE_TIMEOUT, true
+			}
+			if idle > this.GetIdleSize() {
+				if itime > int64(this.peakTimeout) {
+					return EVICT_PEAK_TIMEOUT, true
 				}
 			}
 		}
 	}
-
-	this.wg.Done()
+	return 0, false
 }
 
-func (this *GRPCPool) addUsed() int32 {
-	if metricObserver != nil {
-		metricObserver.IncUsed()
+// 剔除空闲太久或存活太久的 IDLE 状态的连接，并计入度量数据
+func (this *GRPCPool) evictIdle(conn *GRPCConn, reason EvictReason) {
+	this.evict(conn, STATE_IDLE, reason)
+	if mo := this.GetMetricObserver(); mo != nil {
+		if reason == EVICT_PEAK_TIMEOUT {
+			mo.IncPutIdle()
+		} else {
+			mo.IncPutOld()
+		}
 	}
-	return atomic.AddInt32(&this.used, 1)
 }
 
-func (this *GRPCPool) subUsed() int32 {
-	if metricObserver != nil {
-		metricObserver.DecUsed()
+// 将 IDLE 状态的连接放入空闲连接列表，
+// 池已关闭或已满时剔除该连接，返回 SUCCESS、POOL_CLOSED 或 POOL_FULL
+func (this *GRPCPool) pushIdle(conn *GRPCConn) uint32 {
+	errcode := this.idleConns.push(conn)
+	switch errcode {
+	case SUCCESS:
+	case POOL_CLOSED:
+		this.evict(conn, STATE_IDLE, EVICT_POOL_CLOSED)
+	default:
+		this.evict(conn, STATE_IDLE, EVICT_FULL)
 	}
-	return atomic.AddInt32(&this.used, -1)
+	return errcode
 }
 
-func (this *GRPCPool) addIdle() int32 {
-	if metricObserver != nil {
-		metricObserver.IncIdle()
-	}
-	return atomic.AddInt32(&this.idle, 1)
+// 将后台协程新建的 DIALING 状态的连接放入池，池已关闭或已满时关闭该连接
+func (this *GRPCPool) giveIdle(conn *GRPCConn) {
+	this.setState(conn, STATE_DIALING, STATE_IDLE)
+	this.pushIdle(conn)
 }
 
-func (this *GRPCPool) subIdle() int32 {
-	if metricObserver != nil {
-		metricObserver.DecIdle()
-	}
-	return atomic.AddInt32(&this.idle, -1)
+// 从空闲连接列表取出指定的连接（仍为 IDLE 状态），供后台协程剔除检查出问题的连接，
+// 连接已被 Get 取走时返回 false
+func (this *GRPCPool) removeIdle(conn *GRPCConn) bool {
+	return this.idleConns.remove(conn)
 }
 
+// 取得空闲连接数，即 IDLE 状态的连接数
 func (this *GRPCPool) GetIdle() int32 {
-	return atomic.LoadInt32(&this.idle)
+	var idle int32
+	for i := range this.idleConns.counters {
+		idle += atomic.LoadInt32(&this.idleConns.counters[i].states[STATE_IDLE])
+	}
+	return idle
 }
 
+// 取得使用中的连接数，即 DIALING 和 LEASED 状态的连接数
 func (this *GRPCPool) GetUsed() int32 {
-	return atomic.LoadInt32(&this.used)
+	var used int32
+	for i := range this.idleConns.counters {
+		counters := &this.idleConns.counters[i]
+		used += atomic.LoadInt32(&counters.states[STATE_DIALING]) + atomic.LoadInt32(&counters.states[STATE_LEASED])
+	}
+	return used
 }
 
 func (this *GRPCPool) GetInitSize() int32 {
@@ -532,6 +1073,10 @@ func (this *DefaultMetricObserver) IncGetEmpty() int32 {
 	return atomic.AddInt32(&this.metric.GetEmpty, 1)
 }
 
+func (this *DefaultMetricObserver) IncGetLimited() int32 {
+	return atomic.AddInt32(&this.metric.GetLimited, 1)
+}
+
 func (this *DefaultMetricObserver) IncPutSuccess() int32 {
 	return atomic.AddInt32(&this.metric.PutSuccess, 1)
 }
@@ -552,6 +1097,94 @@ func (this *DefaultMetricObserver) IncPutIdle() int32 {
 	return atomic.AddInt32(&this.metric.PutIdle, 1)
 }
 
+func (this *DefaultMetricObserver) IncPutMisuse() int32 {
+	return atomic.AddInt32(&this.metric.PutMisuse, 1)
+}
+
+func (this *DefaultMetricObserver) IncEvictHealth() int32 {
+	return atomic.AddInt32(&this.metric.EvictHealth, 1)
+}
+
+func (this *DefaultMetricObserver) IncEvictBroken() int32 {
+	return atomic.AddInt32(&this.metric.EvictBroken, 1)
+}
+
+func (this *DefaultMetricObserver) IncEvictDisconnected() int32 {
+	return atomic.AddInt32(&this.metric.EvictDisconnected, 1)
+}
+
+func (this *DefaultMetricObserver) ObserveGetWait(d time.Duration) {
+	this.metric.GetWait.Observe(d)
+}
+
+func (this *DefaultMetricObserver) ObserveDial(d time.Duration) {
+	this.metric.DialDuration.Observe(d)
+}
+
+func (this *DefaultMetricObserver) ObserveHold(d time.Duration) {
+	this.metric.HoldTime.Observe(d)
+}
+
+// CloseObserver
+func (this *DefaultMetricObserver) ObserveClose(d time.Duration) {
+	this.metric.CloseDuration.Observe(d)
+}
+
+func (this *DefaultMetricObserver) AddCloseBacklog(delta int32) {
+	atomic.AddInt32(&this.metric.CloseBacklog, delta)
+}
+
+// 耗时分布的快照
+func (this *DefaultMetricObserver) GetGetWait() HistogramSnapshot {
+	return this.metric.GetWait.Snapshot(false)
+}
+
+func (this *DefaultMetricObserver) GetDialDuration() HistogramSnapshot {
+	return this.metric.DialDuration.Snapshot(false)
+}
+
+func (this *DefaultMetricObserver) GetHoldTime() HistogramSnapshot {
+	return this.metric.HoldTime.Snapshot(false)
+}
+
+func (this *DefaultMetricObserver) GetCloseDuration() HistogramSnapshot {
+	return this.metric.CloseDuration.Snapshot(false)
+}
+
+// 耗时百分位数，q 取值范围 [0, 1]，如 0.99 表示 P99
+func (this *DefaultMetricObserver) GetWaitPercentile(q float64) time.Duration {
+	return this.GetGetWait().Percentile(q)
+}
+
+func (this *DefaultMetricObserver) DialDurationPercentile(q float64) time.Duration {
+	return this.GetDialDuration().Percentile(q)
+}
+
+func (this *DefaultMetricObserver) HoldTimePercentile(q float64) time.Duration {
+	return this.GetHoldTime().Percentile(q)
+}
+
+func (this *DefaultMetricObserver) CloseDurationPercentile(q float64) time.Duration {
+	return this.GetCloseDuration().Percentile(q)
+}
+
+// 返回清 0 前的值
+func (this *DefaultMetricObserver) ZeroGetWait() HistogramSnapshot {
+	return this.metric.GetWait.Snapshot(true)
+}
+
+func (this *DefaultMetricObserver) ZeroDialDuration() HistogramSnapshot {
+	return this.metric.DialDuration.Snapshot(true)
+}
+
+func (this *DefaultMetricObserver) ZeroHoldTime() HistogramSnapshot {
+	return this.metric.HoldTime.Snapshot(true)
+}
+
+func (this *DefaultMetricObserver) ZeroCloseDuration() HistogramSnapshot {
+	return this.metric.CloseDuration.Snapshot(true)
+}
+
 // 返回清 0 前的值
 func (this *DefaultMetricObserver) ZeroDialRefused() int32 {
 	return atomic.SwapInt32(&this.metric.DialRefused, 0)
@@ -577,6 +1210,10 @@ func (this *DefaultMetricObserver) ZeroGetEmpty() int32 {
 	return atomic.SwapInt32(&this.metric.GetEmpty, 0)
 }
 
+func (this *DefaultMetricObserver) ZeroGetLimited() int32 {
+	return atomic.SwapInt32(&this.metric.GetLimited, 0)
+}
+
 func (this *DefaultMetricObserver) ZeroPutSuccess() int32 {
 	return atomic.SwapInt32(&this.metric.PutSuccess, 0)
 }
@@ -596,3 +1233,19 @@ func (this *DefaultMetricObserver) ZeroPutOld() int32 {
 func (this *DefaultMetricObserver) ZeroPutIdle() int32 {
 	return atomic.SwapInt32(&this.metric.PutIdle, 0)
 }
+
+func (this *DefaultMetricObserver) ZeroPutMisuse() int32 {
+	return atomic.SwapInt32(&this.metric.PutMisuse, 0)
+}
+
+func (this *DefaultMetricObserver) ZeroEvictHealth() int32 {
+	return atomic.SwapInt32(&this.metric.EvictHealth, 0)
+}
+
+func (this *DefaultMetricObserver) ZeroEvictBroken() int32 {
+	return atomic.SwapInt32(&this.metric.EvictBroken, 0)
+}
+
+func (this *DefaultMetricObserver) ZeroEvictDisconnected() int32 {
+	return atomic.SwapInt32(&this.metric.EvictDisconnected, 0)
+}
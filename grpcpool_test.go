@@ -0,0 +1,690 @@
+package grpcpool_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+import (
+	"github.com/eyjian/grpcpool"
+	"github.com/eyjian/grpcpool/grpcpooltest"
+)
+
+// 创建连接 grpcpooltest 服务端的池，池使用独立的 DefaultMetricObserver 和假时钟
+func newTestPool(t *testing.T, initSize, idleSize, peakSize int32) (*grpcpooltest.Server, *grpcpool.GRPCPool, *grpcpool.DefaultMetricObserver, *grpcpooltest.FakeClock) {
+	server := grpcpooltest.NewServer()
+	pool := server.NewPool(initSize, idleSize, peakSize)
+	mo := new(grpcpool.DefaultMetricObserver)
+	pool.SetMetricObserver(mo)
+	clock := grpcpooltest.NewFakeClock(time.Unix(time.Now().Unix(), 0))
+	pool.SetClock(clock)
+	t.Cleanup(func() {
+		pool.Close()
+		server.Close()
+	})
+	return server, pool, mo, clock
+}
+
+func mustGet(t *testing.T, pool *grpcpool.GRPCPool) *grpcpool.GRPCConn {
+	t.Helper()
+	conn, errcode, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get: errcode %d, %v", errcode, err)
+	}
+	return conn
+}
+
+// 检查池和 MetricObserver 的 used、idle 以及实际连接数
+func checkCounts(t *testing.T, pool *grpcpool.GRPCPool, mo *grpcpool.DefaultMetricObserver, used, idle int32) {
+	t.Helper()
+	if got := pool.GetUsed(); got != used {
+		t.Errorf("pool used = %d, want %d", got, used)
+	}
+	if got := pool.GetIdle(); got != idle {
+		t.Errorf("pool idle = %d, want %d", got, idle)
+	}
+	if got := mo.GetUsed(); got != used {
+		t.Errorf("observer used = %d, want %d", got, used)
+	}
+	if got := mo.GetIdle(); got != idle {
+		t.Errorf("observer idle = %d, want %d", got, idle)
+	}
+	if got := len(pool.Stats().ConnAges); got != int(used+idle) {
+		t.Errorf("conns = %d, want %d", got, used+idle)
+	}
+	if err := pool.CheckInvariants(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetPut(t *testing.T) {
+	_, pool, mo, _ := newTestPool(t, 1, 2, 4)
+
+	conn1 := mustGet(t, pool)
+	conn2 := mustGet(t, pool)
+	checkCounts(t, pool, mo, 2, 0)
+	if conn1.GetID() == conn2.GetID() {
+		t.Errorf("conns have the same ID %d", conn1.GetID())
+	}
+
+	if errcode, err := pool.Put(conn1); errcode != grpcpool.SUCCESS || err != nil {
+		t.Fatalf("Put: errcode %d, %v", errcode, err)
+	}
+	checkCounts(t, pool, mo, 1, 1)
+
+	// 应取到刚放回的连接，不再拨号
+	conn3 := mustGet(t, pool)
+	if conn3 != conn1 {
+		t.Errorf("Get returned a new conn, want the idle one")
+	}
+	pool.Put(conn3)
+	pool.Put(conn2)
+	checkCounts(t, pool, mo, 0, 2)
+
+	metric := mo.Snapshot(false)
+	if metric.DialSuccess != 2 || metric.GetSuccess != 1 || metric.PutSuccess != 3 {
+		t.Errorf("dial success %d, get success %d, put success %d, want 2, 1, 3", metric.DialSuccess, metric.GetSuccess, metric.PutSuccess)
+	}
+	if conn1.GetBorrowCount() != 2 {
+		t.Errorf("borrow count = %d, want 2", conn1.GetBorrowCount())
+	}
+}
+
+func TestPutClosed(t *testing.T) {
+	_, pool, mo, _ := newTestPool(t, 1, 2, 4)
+
+	conn := mustGet(t, pool)
+	conn.Close()
+	if errcode, _ := pool.Put(conn); errcode != grpcpool.CONN_CLOSED {
+		t.Errorf("Put closed conn: errcode %d, want CONN_CLOSED", errcode)
+	}
+	checkCounts(t, pool, mo, 0, 0)
+	if n := mo.Snapshot(false).PutClose; n != 1 {
+		t.Errorf("put close = %d, want 1", n)
+	}
+}
+
+func TestGetEmpty(t *testing.T) {
+	_, pool, mo, _ := newTestPool(t, 1, 1, 2)
+
+	conn1 := mustGet(t, pool)
+	conn2 := mustGet(t, pool)
+	if conn, errcode, _ := pool.Get(context.Background()); conn != nil || errcode != grpcpool.POOL_EMPTY {
+		t.Errorf("Get beyond peak: errcode %d, want POOL_EMPTY", errcode)
+	}
+	checkCounts(t, pool, mo, 2, 0)
+	if n := mo.Snapshot(false).GetEmpty; n != 1 {
+		t.Errorf("get empty = %d, want 1", n)
+	}
+	pool.Put(conn1)
+	pool.Put(conn2)
+}
+
+func TestDialErrors(t *testing.T) {
+	server, pool, mo, _ := newTestPool(t, 1, 2, 4)
+
+	server.SetRefuseDials(true)
+	if conn, errcode, _ := pool.Get(context.Background()); conn != nil || errcode != grpcpool.CONN_UNAVAILABLE {
+		t.Errorf("Get with refused dial: errcode %d, want CONN_UNAVAILABLE", errcode)
+	}
+	server.SetRefuseDials(false)
+
+	server.SetDialDelay(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	conn, errcode, _ := pool.Get(ctx)
+	cancel()
+	if conn != nil || errcode != grpcpool.CONN_DEADLINE_EXCEEDED {
+		t.Errorf("Get with slow dial: errcode %d, want CONN_DEADLINE_EXCEEDED", errcode)
+	}
+
+	checkCounts(t, pool, mo, 0, 0)
+	metric := mo.Snapshot(false)
+	if metric.DialRefused != 1 || metric.DialTimeout != 1 || metric.DialSuccess != 0 {
+		t.Errorf("dial refused %d, timeout %d, success %d, want 1, 1, 0", metric.DialRefused, metric.DialTimeout, metric.DialSuccess)
+	}
+	if n := len(pool.GetDialErrors()); n != 2 {
+		t.Errorf("dial errors = %d, want 2", n)
+	}
+}
+
+// 空闲连接数超过 initSize 且超过 idleTimeout 未使用的，归还时被关闭
+func TestPutIdleTimeout(t *testing.T) {
+	_, pool, mo, clock := newTestPool(t, 1, 2, 4)
+	pool.SetIdleTimeout(10)
+
+	conn1 := mustGet(t, pool)
+	conn2 := mustGet(t, pool)
+	pool.Put(conn1)
+	clock.Advance(11 * time.Second)
+	if errcode, _ := pool.Put(conn2); errcode != grpcpool.POOL_IDLE {
+		t.Errorf("Put: errcode %d, want POOL_IDLE", errcode)
+	}
+	if !conn2.IsClosed() {
+		t.Errorf("conn not closed")
+	}
+	checkCounts(t, pool, mo, 0, 1)
+	if n := mo.Snapshot(false).PutOld; n != 1 {
+		t.Errorf("put old = %d, want 1", n)
+	}
+}
+
+// 空闲连接数超过 idleSize 且超过 peakTimeout 未使用的，归还时被关闭
+func TestPutPeakTimeout(t *testing.T) {
+	_, pool, mo, clock := newTestPool(t, 1, 2, 4)
+	pool.SetIdleTimeout(10)
+	pool.SetPeakTimeout(2)
+
+	conn1 := mustGet(t, pool)
+	conn2 := mustGet(t, pool)
+	conn3 := mustGet(t, pool)
+	pool.Put(conn1)
+	pool.Put(conn2)
+	clock.Advance(3 * time.Second)
+	if errcode, _ := pool.Put(conn3); errcode != grpcpool.POOL_IDLE {
+		t.Errorf("Put: errcode %d, want POOL_IDLE", errcode)
+	}
+	checkCounts(t, pool, mo, 0, 2)
+	if n := mo.Snapshot(false).PutIdle; n != 1 {
+		t.Errorf("put idle = %d, want 1", n)
+	}
+}
+
+// releaseIdleCoroutine 先按 peakTimeout 将空闲连接减到 idleSize，再按 idleTimeout 减到 initSize
+func TestReleaseIdle(t *testing.T) {
+	_, pool, mo, clock := newTestPool(t, 1, 2, 4)
+	pool.SetIdleTimeout(10)
+	pool.SetPeakTimeout(2)
+
+	conns := []*grpcpool.GRPCConn{mustGet(t, pool), mustGet(t, pool), mustGet(t, pool)}
+	for _, conn := range conns {
+		pool.Put(conn)
+	}
+	checkCounts(t, pool, mo, 0, 3)
+
+	// releaseIdleCoroutine 只在最久未用的连接到期时被唤醒，重新计时时停止原来的定时器，
+	// 因此任何时候至多有一个定时器
+	waitReaper := func() {
+		t.Helper()
+		if !clock.WaitForWaiters(1, time.Second) {
+			t.Fatalf("releaseIdleCoroutine is not waiting on the clock")
+		}
+		if n := clock.GetWaiters(); n != 1 {
+			t.Fatalf("clock waiters = %d, want 1", n)
+		}
+	}
+	waitIdle := func(idle int32) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for pool.GetIdle() != idle && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		checkCounts(t, pool, mo, 0, idle)
+	}
+	notExpired := func(idle int32) {
+		t.Helper()
+		time.Sleep(10 * time.Millisecond)
+		checkCounts(t, pool, mo, 0, idle)
+	}
+
+	// 超过 idleSize，空闲超过 peakTimeout 后到期
+	waitReaper()
+	clock.Advance(2 * time.Second)
+	notExpired(3)
+	clock.Advance(time.Second)
+	waitIdle(2)
+
+	// 超过 initSize，空闲超过 idleTimeout 后到期
+	waitReaper()
+	clock.Advance(7 * time.Second)
+	notExpired(2)
+	clock.Advance(time.Second)
+	waitIdle(1)
+
+	// 已达 initSize，不再计时，Put 使空闲连接数超过 initSize 后才重新计时
+	clock.Advance(time.Hour)
+	checkCounts(t, pool, mo, 0, 1)
+	conn1, conn2 := mustGet(t, pool), mustGet(t, pool)
+	pool.Put(conn1)
+	pool.Put(conn2)
+	waitReaper()
+	clock.Advance(11 * time.Second)
+	waitIdle(1)
+
+	metric := mo.Snapshot(false)
+	if metric.PutIdle != 1 || metric.PutOld != 2 {
+		t.Errorf("put idle %d, put old %d, want 1, 2", metric.PutIdle, metric.PutOld)
+	}
+	if metric.PutSuccess != 5 || metric.GetSuccess != 1 {
+		t.Errorf("put success %d, get success %d, want 5, 1", metric.PutSuccess, metric.GetSuccess)
+	}
+}
+
+// 直接调用 releaseIdle，与 releaseIdleCoroutine 的结果一致
+func TestReleaseIdleDirect(t *testing.T) {
+	_, pool, mo, clock := newTestPool(t, 1, 2, 4)
+	pool.SetIdleTimeout(10)
+	pool.SetPeakTimeout(2)
+
+	conns := []*grpcpool.GRPCConn{mustGet(t, pool), mustGet(t, pool), mustGet(t, pool)}
+	for _, conn := range conns {
+		pool.Put(conn)
+	}
+	clock.Advance(5 * time.Second)
+	pool.ReleaseIdle()
+	checkCounts(t, pool, mo, 0, 2)
+	clock.Advance(6 * time.Second)
+	pool.ReleaseIdle()
+	checkCounts(t, pool, mo, 0, 1)
+}
+
+func TestClose(t *testing.T) {
+	_, pool, mo, _ := newTestPool(t, 1, 2, 4)
+
+	conn1 := mustGet(t, pool)
+	conn2 := mustGet(t, pool)
+	pool.Put(conn1)
+	pool.Close()
+	if !conn1.IsClosed() {
+		t.Errorf("idle conn not closed by Close")
+	}
+	if conn, errcode, _ := pool.Get(context.Background()); conn != nil || errcode != grpcpool.POOL_CLOSED {
+		t.Errorf("Get after Close: errcode %d, want POOL_CLOSED", errcode)
+	}
+
+	// 关闭后归还的连接被关闭
+	pool.Put(conn2)
+	if !conn2.IsClosed() {
+		t.Errorf("conn returned after Close not closed")
+	}
+	checkCounts(t, pool, mo, 0, 0)
+	pool.Close()
+}
+
+// Close 与并发的 Get、Put 竞争，之后计数应归 0，且所有连接都被关闭
+func TestCloseRacingPut(t *testing.T) {
+	for round := 0; round < 10; round++ {
+		_, pool, mo, _ := newTestPool(t, 1, 4, 8)
+
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		var conns []*grpcpool.GRPCConn
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					conn, _, err := pool.Get(context.Background())
+					if err != nil {
+						continue
+					}
+					mutex.Lock()
+					conns = append(conns, conn)
+					mutex.Unlock()
+					pool.Put(conn)
+				}
+			}()
+		}
+		time.Sleep(time.Millisecond)
+		pool.Close()
+		wg.Wait()
+
+		checkCounts(t, pool, mo, 0, 0)
+		for _, conn := range conns {
+			if !conn.IsClosed() {
+				t.Fatalf("round %d: conn %d not closed after Close", round, conn.GetID())
+			}
+		}
+	}
+}
+
+// 并发 Get、Put（部分连接在使用中被关闭），used 和 idle 始终不为负，结束时与实际连接数一致
+func TestConcurrentGetPut(t *testing.T) {
+	_, pool, mo, _ := newTestPool(t, 2, 4, 8)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if used, idle := pool.GetUsed(), pool.GetIdle(); used < 0 || idle < 0 {
+				t.Errorf("negative counts: used %d, idle %d", used, idle)
+				return
+			}
+		}
+	}()
+
+	var workers sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		workers.Add(1)
+		go func(i int) {
+			defer workers.Done()
+			for j := 0; j < 200; j++ {
+				conn, _, err := pool.Get(context.Background())
+				if err != nil {
+					continue
+				}
+				if (i+j)%17 == 0 {
+					conn.Close()
+				}
+				pool.Put(conn)
+			}
+		}(i)
+	}
+	workers.Wait()
+	close(stop)
+	wg.Wait()
+
+	checkCounts(t, pool, mo, 0, pool.GetIdle())
+	metric := mo.Snapshot(false)
+	puts := metric.PutSuccess + metric.PutFull + metric.PutClose + metric.PutOld + metric.PutIdle
+	gets := metric.GetSuccess + metric.DialSuccess
+	if puts != gets {
+		t.Errorf("puts %d != gets %d", puts, gets)
+	}
+}
+
+// 低负载时只需一个连接：后进先出使其余连接超时关闭而收缩到 initSize，
+// 先进先出则轮流使用，只有高峰超时生效，最近使用时间不断被刷新而停在 idleSize
+func TestSelectPolicy(t *testing.T) {
+	// 默认与原来的 chan 队列一致
+	if _, pool, _, _ := newTestPool(t, 1, 2, 4); pool.GetSelectPolicy() != grpcpool.SELECT_FIFO {
+		t.Errorf("default policy = %s, want fifo", pool.GetSelectPolicy())
+	}
+
+	for _, tc := range []struct {
+		policy grpcpool.SelectPolicy
+		idle   int32
+	}{
+		{grpcpool.SELECT_LIFO, 1},
+		{grpcpool.SELECT_FIFO, 2},
+	} {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			_, pool, mo, clock := newTestPool(t, 1, 2, 4)
+			pool.SetIdleTimeout(10)
+			pool.SetPeakTimeout(2)
+			pool.SetSelectPolicy(tc.policy)
+
+			conns := []*grpcpool.GRPCConn{mustGet(t, pool), mustGet(t, pool), mustGet(t, pool)}
+			for _, conn := range conns {
+				pool.Put(conn)
+			}
+			for i := 0; i < 12; i++ {
+				clock.Advance(time.Second)
+				pool.Put(mustGet(t, pool))
+			}
+			pool.ReleaseIdle()
+			checkCounts(t, pool, mo, 0, tc.idle)
+		})
+	}
+}
+
+func TestSelectRandom(t *testing.T) {
+	_, pool, mo, _ := newTestPool(t, 1, 4, 4)
+	pool.SetSelectPolicy(grpcpool.SELECT_RANDOM)
+
+	conns := []*grpcpool.GRPCConn{mustGet(t, pool), mustGet(t, pool), mustGet(t, pool), mustGet(t, pool)}
+	for _, conn := range conns {
+		pool.Put(conn)
+	}
+	seen := make(map[*grpcpool.GRPCConn]bool)
+	for i := 0; i < 4; i++ {
+		seen[mustGet(t, pool)] = true
+	}
+	if len(seen) != 4 {
+		t.Errorf("got %d distinct conns, want 4", len(seen))
+	}
+	for conn := range seen {
+		pool.Put(conn)
+	}
+	checkCounts(t, pool, mo, 0, 4)
+}
+
+// 分片的池：限制和计数是全局的，空闲连接可从其它分片取到（不新拨号），releaseIdle 跨分片关闭最久未用的
+func TestSharded(t *testing.T) {
+	server := grpcpooltest.NewServer()
+	pool := grpcpool.NewShardedGRPCPool(grpcpooltest.ENDPOINT, 4, 1, 2, 8, server.DialOptions()...)
+	mo := new(grpcpool.DefaultMetricObserver)
+	pool.SetMetricObserver(mo)
+	clock := grpcpooltest.NewFakeClock(time.Unix(time.Now().Unix(), 0))
+	pool.SetClock(clock)
+	t.Cleanup(func() {
+		pool.Close()
+		server.Close()
+	})
+	pool.SetIdleTimeout(10)
+	pool.SetPeakTimeout(2)
+	if shards := pool.Stats().Shards; shards != 4 {
+		t.Fatalf("shards = %d, want 4", shards)
+	}
+
+	var conns []*grpcpool.GRPCConn
+	for i := 0; i < 8; i++ {
+		conns = append(conns, mustGet(t, pool))
+	}
+	if conn, errcode, _ := pool.Get(context.Background()); conn != nil || errcode != grpcpool.POOL_EMPTY {
+		t.Errorf("Get beyond peak: errcode %d, want POOL_EMPTY", errcode)
+	}
+	for _, conn := range conns {
+		pool.Put(conn)
+	}
+	checkCounts(t, pool, mo, 0, 8)
+
+	// 并发取走全部空闲连接，不论在哪个分片都应取到
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	conns = conns[:0]
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, _, err := pool.Get(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mutex.Lock()
+			conns = append(conns, conn)
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+	if n := mo.Snapshot(false).DialSuccess; n != 8 {
+		t.Errorf("dial success = %d, want 8", n)
+	}
+	for _, conn := range conns {
+		pool.Put(conn)
+	}
+
+	clock.Advance(11 * time.Second)
+	pool.ReleaseIdle()
+	checkCounts(t, pool, mo, 0, 1)
+}
+
+func TestConnState(t *testing.T) {
+	_, pool, mo, _ := newTestPool(t, 1, 2, 4)
+
+	conn := mustGet(t, pool)
+	if state := conn.GetPoolState(); state != grpcpool.STATE_LEASED {
+		t.Errorf("state after Get = %s, want leased", state)
+	}
+	pool.Put(conn)
+	if state := conn.GetPoolState(); state != grpcpool.STATE_IDLE {
+		t.Errorf("state after Put = %s, want idle", state)
+	}
+	checkCounts(t, pool, mo, 0, 1)
+
+	conn = mustGet(t, pool)
+	conn.Close()
+	pool.Put(conn)
+	if state := conn.GetPoolState(); state != grpcpool.STATE_CLOSED {
+		t.Errorf("state after Put of closed conn = %s, want closed", state)
+	}
+	checkCounts(t, pool, mo, 0, 0)
+	if counts := pool.GetStateCounts(); counts != [grpcpool.MAX_CONN_STATE]int32{} {
+		t.Errorf("state counts = %v, want all 0", counts)
+	}
+}
+
+func TestMisuse(t *testing.T) {
+	if grpcpool.PANIC_ON_MISUSE {
+		t.Skip("misuse panics in grpcpooldebug mode")
+	}
+	server, pool, mo, _ := newTestPool(t, 1, 2, 4)
+	other := server.NewPool(1, 2, 4)
+	defer other.Close()
+
+	conn := mustGet(t, pool)
+	errcode, err := other.Put(conn)
+	if errcode != grpcpool.CONN_MISUSED || !errors.Is(err, grpcpool.ErrForeignPut) {
+		t.Errorf("Put to other pool = %d, %v, want CONN_MISUSED and ErrForeignPut", errcode, err)
+	}
+	if used := other.GetUsed(); used != 0 {
+		t.Errorf("other pool used = %d, want 0", used)
+	}
+
+	// 仍可正常归还到自己的池
+	if errcode, err := pool.Put(conn); errcode != grpcpool.SUCCESS {
+		t.Fatalf("Put = %d, %v", errcode, err)
+	}
+	errcode, err = pool.Put(conn)
+	var misuseErr *grpcpool.MisuseError
+	if errcode != grpcpool.CONN_MISUSED || !errors.Is(err, grpcpool.ErrDoublePut) || !errors.As(err, &misuseErr) {
+		t.Fatalf("second Put = %d, %v, want CONN_MISUSED and ErrDoublePut", errcode, err)
+	}
+	if misuseErr.ConnID != conn.GetID() || misuseErr.State != grpcpool.STATE_IDLE {
+		t.Errorf("MisuseError = %+v", misuseErr)
+	}
+	checkCounts(t, pool, mo, 0, 1)
+	if n := mo.Snapshot(false).PutMisuse; n != 1 {
+		t.Errorf("PutMisuse = %d, want 1", n)
+	}
+
+	// 并发的重复 Put 只有一个成功
+	conn = mustGet(t, pool)
+	var wg sync.WaitGroup
+	var successes int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errcode, _ := pool.Put(conn); errcode == grpcpool.SUCCESS {
+				atomic.AddInt32(&successes, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if successes != 1 {
+		t.Errorf("%d concurrent Puts succeeded, want 1", successes)
+	}
+	checkCounts(t, pool, mo, 0, 1)
+}
+
+// 使用者关闭连接、读取连接的统计与池的 Get/Put 和 releaseIdleCoroutine 并发进行，
+// 应能通过 -race，且每个 gRPC 连接只被关闭一次
+func TestConcurrentConnClose(t *testing.T) {
+	_, pool, mo, clock := newTestPool(t, 1, 4, 8)
+
+	conns := make([]*grpcpool.GRPCConn, 8)
+	for i := range conns {
+		conns[i] = mustGet(t, pool)
+	}
+	for _, conn := range conns {
+		pool.Put(conn)
+	}
+
+	var wg sync.WaitGroup
+	var closeErrors int32
+	stop := make(chan struct{})
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			clock.Advance(time.Second)
+			pool.ReleaseIdle()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			for _, conn := range conns {
+				conn.IsClosed()
+				conn.GetUseTime()
+				conn.GetBorrowCount()
+				conn.GetPoolState()
+			}
+		}
+	}()
+
+	var workers sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		workers.Add(1)
+		go func(i int) {
+			defer workers.Done()
+			for j := 0; j < 100; j++ {
+				if conn, _, err := pool.Get(context.Background()); err == nil {
+					pool.Put(conn)
+				}
+				if j%10 == 0 {
+					if err := conns[(i+j)%len(conns)].Close(); err != nil {
+						atomic.AddInt32(&closeErrors, 1)
+					}
+				}
+			}
+		}(i)
+	}
+	workers.Wait()
+	close(stop)
+	wg.Wait()
+
+	pool.Close()
+	for _, conn := range conns {
+		if !conn.IsClosed() {
+			t.Errorf("conn %d is not closed", conn.GetID())
+		}
+		if err := conn.Close(); err != nil {
+			t.Errorf("Close of closed conn %d = %v", conn.GetID(), err)
+		}
+	}
+	if closeErrors != 0 {
+		t.Errorf("%d Close calls failed", closeErrors)
+	}
+	checkCounts(t, pool, mo, 0, 0)
+}
+
+// SwapMetricObserver 返回原来设置的观察者，未设置过时为 nil（此时实际使用全局的）
+func TestSwapMetricObserver(t *testing.T) {
+	server := grpcpooltest.NewServer()
+	defer server.Close()
+	pool := server.NewPool(0, 1, 1)
+	defer pool.Close()
+
+	mo := new(grpcpool.DefaultMetricObserver)
+	if old := pool.SwapMetricObserver(mo); old != nil {
+		t.Errorf("first swap returned %T, want nil", old)
+	}
+	if old := pool.SwapMetricObserver(nil); old != grpcpool.MetricObserver(mo) {
+		t.Errorf("second swap returned %T, want the one set before", old)
+	}
+	if pool.GetMetricObserver() == grpcpool.MetricObserver(mo) {
+		t.Errorf("observer after swapping back to nil is still the pool's own")
+	}
+}
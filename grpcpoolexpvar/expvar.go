@@ -0,0 +1,54 @@
+// Package grpcpoolexpvar 通过标准库 expvar 发布 grpcpool 连接池的状态，
+// 可在 /debug/vars 中查看，不依赖 Prometheus 等第三方库。
+//
+// 导入 expvar 会在 http.DefaultServeMux 上注册 /debug/vars，
+// 为不给 grpcpool 本身带来这一副作用，本功能放在单独的包中。
+//
+// 使用方法：
+//
+//	grpcpoolexpvar.PublishExpvar("grpcpool_hello", gRPCPool)
+package grpcpoolexpvar
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+)
+import (
+	"github.com/eyjian/grpcpool"
+)
+
+// 发布的内容
+type Var struct {
+	Stats    grpcpool.Stats   // 池的统计快照
+	Observer *grpcpool.Metric // 池的 MetricObserver（如 DefaultMetricObserver）的计数，未实现 MetricSnapshotter 时为 nil
+}
+
+// 串行化 PublishExpvar 的检查和发布，以免并发发布同名的时 expvar.Publish 因重名而 panic
+var publishMutex sync.Mutex
+
+// 以 name 为名发布池的状态，每次读取 /debug/vars 时实时取值。
+// expvar 不支持撤销发布，池被关闭后仍可读取（Stats.Closed 为 true）。
+// name 已被使用时返回错误（并发调用也是如此，但不能防止同时有其它代码直接调用 expvar.Publish）。
+func PublishExpvar(name string, pool *grpcpool.GRPCPool) error {
+	publishMutex.Lock()
+	defer publishMutex.Unlock()
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("expvar %s is already published", name)
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return Snapshot(pool)
+	}))
+	return nil
+}
+
+// 取得发布的内容
+func Snapshot(pool *grpcpool.GRPCPool) *Var {
+	v := new(Var)
+	v.Stats = pool.Stats()
+	if snapshotter, ok := pool.GetMetricObserver().(grpcpool.MetricSnapshotter); ok {
+		metric := snapshotter.Snapshot(false)
+		v.Observer = &metric
+	}
+	return v
+}
@@ -0,0 +1,117 @@
+package grpcpoolexpvar_test
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+import (
+	"github.com/eyjian/grpcpool"
+	"github.com/eyjian/grpcpool/grpcpoolexpvar"
+	"github.com/eyjian/grpcpool/grpcpooltest"
+)
+
+// 创建服务端和连接它的池（initSize 0、idleSize 2、peakSize 4），池使用独立的 DefaultMetricObserver，测试结束时关闭池和服务端
+func newPool(t *testing.T) (*grpcpool.GRPCPool, *grpcpool.DefaultMetricObserver) {
+	server := grpcpooltest.NewServer()
+	pool := server.NewPool(0, 2, 4)
+	mo := new(grpcpool.DefaultMetricObserver)
+	pool.SetMetricObserver(mo)
+	t.Cleanup(func() {
+		pool.Close()
+		server.Close()
+	})
+	return pool, mo
+}
+
+// 取还 n 次：第一次新拨号，之后取到的都是空闲的，
+// 即一次拨号、n-1 次取池成功（新拨号的不计为取池成功）、n 次还池成功
+func getPut(t *testing.T, pool *grpcpool.GRPCPool, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		conn, errcode, err := pool.Get(context.Background())
+		if err != nil {
+			t.Fatalf("Get: errcode %d, %v", errcode, err)
+		}
+		if errcode, err := pool.Put(conn); err != nil {
+			t.Fatalf("Put: errcode %d, %v", errcode, err)
+		}
+	}
+}
+
+var names int32 // 已生成的名字数，用于生成不重复的名字
+
+// 以测试名和序号生成本次运行未被使用过的名字，expvar 不支持撤销发布，用固定的名字时 -count 大于 1 会失败
+func uniqueName(t *testing.T) string {
+	return fmt.Sprintf("%s_%d", strings.ToLower(t.Name()), atomic.AddInt32(&names, 1))
+}
+
+// /debug/vars 中发布的内容为 Var 的 JSON，每次读取实时取值
+func TestPublishExpvar(t *testing.T) {
+	pool, _ := newPool(t)
+	name := uniqueName(t)
+	if err := grpcpoolexpvar.PublishExpvar(name, pool); err != nil {
+		t.Fatalf("PublishExpvar: %v", err)
+	}
+	getPut(t, pool, 2)
+
+	var v struct {
+		Stats    map[string]json.RawMessage
+		Observer map[string]json.RawMessage
+	}
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &v); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	for _, key := range []string{"Endpoint", "InitSize", "IdleSize", "PeakSize", "Shards", "Closed", "ConnAges", "Metric"} {
+		if _, ok := v.Stats[key]; !ok {
+			t.Errorf("Stats.%s is missing", key)
+		}
+	}
+	if endpoint := string(v.Stats["Endpoint"]); endpoint != `"`+grpcpooltest.ENDPOINT+`"` {
+		t.Errorf("Stats.Endpoint = %s, want %q", endpoint, grpcpooltest.ENDPOINT)
+	}
+	if put := string(v.Observer["PutSuccess"]); put != "2" {
+		t.Errorf("Observer.PutSuccess = %s, want 2", put)
+	}
+
+	// 未实现 MetricSnapshotter 的观察者不输出 Observer
+	pool.SetMetricObserver(struct{ grpcpool.MetricObserver }{pool.GetMetricObserver()})
+	if observer := grpcpoolexpvar.Snapshot(pool).Observer; observer != nil {
+		t.Errorf("Observer = %+v, want nil", observer)
+	}
+}
+
+// 并发发布同一名字时只有一个成功，其余返回错误而不是 panic
+func TestPublishExpvarDuplicate(t *testing.T) {
+	pool, _ := newPool(t)
+	name := uniqueName(t)
+	const n = 8
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = grpcpoolexpvar.PublishExpvar(name, pool)
+		}(i)
+	}
+	wg.Wait()
+
+	published := 0
+	for _, err := range errs {
+		if err == nil {
+			published++
+		}
+	}
+	if published != 1 {
+		t.Errorf("%d of %d concurrent PublishExpvar succeeded, want 1", published, n)
+	}
+	if err := grpcpoolexpvar.PublishExpvar(name, pool); err == nil {
+		t.Errorf("PublishExpvar with a used name succeeded, want an error")
+	}
+}
@@ -0,0 +1,66 @@
+// Package grpcpoollogrus 将 logrus 接入 grpcpool 的日志。
+//
+// 为避免 grpcpool 本身依赖 logrus，本包是一个独立的 module。
+//
+// 使用方法：
+//
+//	grpcpool.RegisterLogger(grpcpoollogrus.New(logrus.StandardLogger()))
+package grpcpoollogrus
+
+import (
+	"fmt"
+)
+import (
+	"github.com/eyjian/grpcpool"
+	"github.com/sirupsen/logrus"
+)
+
+// 基于 logrus.FieldLogger 的 grpcpool.Logger，keyvals 转换为 logrus.Fields
+type Logger struct {
+	entry logrus.FieldLogger
+}
+
+// 创建 grpcpool.Logger，l 为 nil 时使用 logrus.StandardLogger()，
+// 输出的日志均带有 component=grpcpool 字段。
+func New(l logrus.FieldLogger) *Logger {
+	if l == nil {
+		l = logrus.StandardLogger()
+	}
+	return &Logger{entry: l.WithField("component", "grpcpool")}
+}
+
+func (this *Logger) Debug(msg string, keyvals ...interface{}) {
+	this.entry.WithFields(fields(keyvals)).Debug(msg)
+}
+
+func (this *Logger) Info(msg string, keyvals ...interface{}) {
+	this.entry.WithFields(fields(keyvals)).Info(msg)
+}
+
+func (this *Logger) Warn(msg string, keyvals ...interface{}) {
+	this.entry.WithFields(fields(keyvals)).Warn(msg)
+}
+
+func (this *Logger) Error(msg string, keyvals ...interface{}) {
+	this.entry.WithFields(fields(keyvals)).Error(msg)
+}
+
+// 将交替出现的键和值转换为 logrus.Fields，
+// 键不是字符串时用 fmt.Sprint 转换，落单的值以 !BADKEY 为键（与 log/slog 一致）
+func fields(keyvals []interface{}) logrus.Fields {
+	f := make(logrus.Fields, len(keyvals)/2)
+	for i := 0; i < len(keyvals); i += 2 {
+		if i+1 == len(keyvals) {
+			f["!BADKEY"] = keyvals[i]
+			break
+		}
+		key, ok := keyvals[i].(string)
+		if !ok {
+			key = fmt.Sprint(keyvals[i])
+		}
+		f[key] = keyvals[i+1]
+	}
+	return f
+}
+
+var _ grpcpool.Logger = (*Logger)(nil)
@@ -0,0 +1,60 @@
+package grpcpoollogrus_test
+
+import (
+	"context"
+	"testing"
+)
+import (
+	"github.com/eyjian/grpcpool/grpcpoollogrus"
+	"github.com/eyjian/grpcpool/grpcpooltest"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+)
+
+// 被拒绝的拨号记 Warn（带 error），关闭池记 Info
+func TestNew(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	server := grpcpooltest.NewServer()
+	defer server.Close()
+	pool := server.NewPool(0, 1, 1)
+	pool.SetLogger(grpcpoollogrus.New(logger))
+	server.SetRefuseDials(true)
+	if _, _, err := pool.Get(context.Background()); err == nil {
+		t.Fatal("Get succeeded while dials are refused")
+	}
+	pool.Close()
+
+	entries := make(map[string]logrus.Entry)
+	for _, entry := range hook.AllEntries() {
+		if entry.Data["component"] != "grpcpool" || entry.Data["endpoint"] != grpcpooltest.ENDPOINT {
+			t.Errorf("%q: component %v, endpoint %v", entry.Message, entry.Data["component"], entry.Data["endpoint"])
+		}
+		entries[entry.Message] = *entry
+	}
+	for msg, level := range map[string]logrus.Level{
+		"grpcpool dial failed": logrus.WarnLevel,
+		"grpcpool closed":      logrus.InfoLevel,
+	} {
+		if entry, ok := entries[msg]; !ok {
+			t.Errorf("no %q log", msg)
+		} else if entry.Level != level {
+			t.Errorf("%q: level %s, want %s", msg, entry.Level, level)
+		}
+	}
+	if entry, ok := entries["grpcpool dial failed"]; ok && entry.Data["error"] == nil {
+		t.Errorf("%q has no error", "grpcpool dial failed")
+	}
+}
+
+// 落单的值以 !BADKEY 为键，非字符串的键用 fmt.Sprint 转换
+func TestFields(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	grpcpoollogrus.New(logger).Info("msg", 1, "one", "odd")
+	entry := hook.LastEntry()
+	if entry == nil {
+		t.Fatal("no log")
+	}
+	if entry.Data["1"] != "one" || entry.Data["!BADKEY"] != "odd" {
+		t.Errorf("fields = %v", entry.Data)
+	}
+}
@@ -0,0 +1,438 @@
+// Package grpcpoolotel 将 grpcpool 连接池接入 OpenTelemetry。
+//
+// 为避免 grpcpool 本身依赖 OpenTelemetry，本包是一个独立的 module。
+//
+// 使用方法：
+//
+//	// 传 nil 表示使用 otel.GetMeterProvider() 和 otel.GetTracerProvider()
+//	instrumentation, err := grpcpoolotel.Instrument(gRPCPool, nil, nil) // 可对使用中的池调用
+//	...
+//	instrumentation.Close() // 撤销接入，池恢复使用接入前的观察者和跟踪器
+//
+// 度量数据（均带 grpcpool.endpoint 属性）：
+// 1) grpcpool.used、grpcpool.idle：使用中和空闲的连接数（UpDownCounter）；
+// 2) grpcpool.dial、grpcpool.get、grpcpool.put：拨号、取池、还池次数（Counter，以 grpcpool.result 属性区分结果）；
+// 3) grpcpool.evict：健康检查失败、broken 和 disconnected 的剔除数（Counter，以 grpcpool.reason 属性区分原因）；
+// 4) grpcpool.get.wait、grpcpool.dial.duration、grpcpool.hold：Get 和拨号的耗时、连接的持有时长（Histogram，单位：秒）。
+//
+// 跟踪：每次 Get 创建一个 grpcpool.Get span，其父 span 来自传给 Get 的 context，
+// 新拨号时再创建一个子 span grpcpool.Dial。
+package grpcpoolotel
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+import (
+	"github.com/eyjian/grpcpool"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/eyjian/grpcpool/grpcpoolotel"
+
+// 属性名
+const (
+	EndpointKey = attribute.Key("grpcpool.endpoint") // 服务端的端点
+	ResultKey   = attribute.Key("grpcpool.result")   // 操作结果，如 success、empty、full 等
+	DialedKey   = attribute.Key("grpcpool.dialed")   // Get 取到的连接是否为新拨号创建的
+	WaitKey     = attribute.Key("grpcpool.wait")     // Get 的耗时（单位：秒）
+	ErrcodeKey  = attribute.Key("grpcpool.errcode")  // Get 返回的错误代码
+	ReasonKey   = attribute.Key("grpcpool.reason")   // 剔除原因，如 health、broken、disconnected
+)
+
+// 对接口 grpcpool.MetricObserver、grpcpool.LatencyObserver、grpcpool.LimitObserver、grpcpool.MisuseObserver、grpcpool.CloseObserver、grpcpool.EvictObserver 和 grpcpool.Tracer 的实现
+type Instrumentation struct {
+	endpoint   attribute.KeyValue
+	metric     grpcpool.Metric         // 供 MetricObserver 的返回值用
+	pool       *grpcpool.GRPCPool      // 由 Instrument 接入的池，Close 时恢复它，NewInstrumentation 创建的为 nil
+	next       grpcpool.MetricObserver // 接入前池使用的观察者（包括全局的），度量数据同时转给它（由 Instrument 设置，可能为 nil）
+	prev       grpcpool.MetricObserver // 接入前用 SetMetricObserver 为池设置的观察者，未设置过时为 nil，Close 时恢复
+	prevTracer grpcpool.Tracer         // 接入前池的跟踪器，Close 时恢复
+	tracer     trace.Tracer
+
+	used          metric.Int64UpDownCounter
+	idle          metric.Int64UpDownCounter
+	dial          metric.Int64Counter
+	get           metric.Int64Counter
+	put           metric.Int64Counter
+	evict         metric.Int64Counter
+	getWait       metric.Float64Histogram
+	dialDuration  metric.Float64Histogram
+	holdTime      metric.Float64Histogram
+	closeDuration metric.Float64Histogram
+	closeBacklog  metric.Int64UpDownCounter
+
+	// 预先构造好的属性集，避免每次记录时分配
+	endpointSet metric.MeasurementOption
+	resultSets  map[string]metric.MeasurementOption
+	reasonSets  map[grpcpool.EvictReason]metric.MeasurementOption
+}
+
+// 为池接入 OpenTelemetry，用池的成员函数 CompareAndSwapMetricObserver 包装池原来的观察者（而不是替换），
+// 用 CompareAndSwapTracer 替换池的跟踪器，读取原来的和安装是一步，与 grpcpoolprom 的 Register 等并发时不会互相覆盖，
+// 因此可对使用中的池调用（接入前的计数不被记录）。调用返回值的 Close 撤销接入。
+// meterProvider 和 tracerProvider 为 nil 时使用 otel 的全局 Provider。
+func Instrument(pool *grpcpool.GRPCPool, meterProvider metric.MeterProvider, tracerProvider trace.TracerProvider) (*Instrumentation, error) {
+	instrumentation, err := NewInstrumentation(pool.GetEndpoint(), meterProvider, tracerProvider)
+	if err != nil {
+		return nil, err
+	}
+	instrumentation.pool = pool
+	for {
+		next := pool.GetMetricObserver()
+		instrumentation.next = next
+		if pool.CompareAndSwapMetricObserver(next, instrumentation) {
+			instrumentation.prev = next
+			break
+		}
+		// 未用 SetMetricObserver 设置过时 GetMetricObserver 返回的是全局的
+		if pool.CompareAndSwapMetricObserver(nil, instrumentation) {
+			instrumentation.prev = nil
+			break
+		}
+	}
+	for {
+		tracer := pool.GetTracer()
+		if pool.CompareAndSwapTracer(tracer, instrumentation) {
+			instrumentation.prevTracer = tracer
+			break
+		}
+	}
+	return instrumentation, nil
+}
+
+// 撤销 Instrument 的接入：池的观察者和跟踪器仍是本 Instrumentation 时分别恢复为接入前的，
+// 接入后又被包装或替换过的（如 grpcpoolprom 的 Register）不恢复，以免把它们一并去掉（本 Instrumentation 仍在其中转发计数）。
+// NewInstrumentation 创建的什么也不做
+func (this *Instrumentation) Close() {
+	if this.pool == nil {
+		return
+	}
+	this.pool.CompareAndSwapMetricObserver(this, this.prev)
+	this.pool.CompareAndSwapTracer(this, this.prevTracer)
+}
+
+// 创建 Instrumentation，需自行调用池的成员函数 SetMetricObserver 和 SetTracer 设置，
+// 不转给池原来的观察者，Close 什么也不做
+func NewInstrumentation(endpoint string, meterProvider metric.MeterProvider, tracerProvider trace.TracerProvider) (*Instrumentation, error) {
+	if meterProvider == nil {
+		meterProvider = otel.GetMeterProvider()
+	}
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+	meter := meterProvider.Meter(instrumentationName)
+
+	var err error
+	instrumentation := new(Instrumentation)
+	instrumentation.endpoint = EndpointKey.String(endpoint)
+	instrumentation.tracer = tracerProvider.Tracer(instrumentationName)
+	if instrumentation.used, err = meter.Int64UpDownCounter("grpcpool.used",
+		metric.WithDescription("Number of connections in use (not in the pool).")); err != nil {
+		return nil, err
+	}
+	if instrumentation.idle, err = meter.Int64UpDownCounter("grpcpool.idle",
+		metric.WithDescription("Number of idle connections in the pool.")); err != nil {
+		return nil, err
+	}
+	if instrumentation.dial, err = meter.Int64Counter("grpcpool.dial",
+		metric.WithDescription("Number of gRPC dials by result (success, refused, timeout, error).")); err != nil {
+		return nil, err
+	}
+	if instrumentation.get, err = meter.Int64Counter("grpcpool.get",
+		metric.WithDescription("Number of Get calls by result (success, empty, limited).")); err != nil {
+		return nil, err
+	}
+	if instrumentation.put, err = meter.Int64Counter("grpcpool.put",
+		metric.WithDescription("Number of Put calls by result (success, full, close, old, idle, misuse).")); err != nil {
+		return nil, err
+	}
+	if instrumentation.evict, err = meter.Int64Counter("grpcpool.evict",
+		metric.WithDescription("Number of connections evicted outside Put accounting by reason (health, broken, disconnected).")); err != nil {
+		return nil, err
+	}
+	if instrumentation.getWait, err = meter.Float64Histogram("grpcpool.get.wait", metric.WithUnit("s"),
+		metric.WithDescription("Time spent in Get, including dialing a new connection.")); err != nil {
+		return nil, err
+	}
+	if instrumentation.dialDuration, err = meter.Float64Histogram("grpcpool.dial.duration", metric.WithUnit("s"),
+		metric.WithDescription("Time spent in grpc.DialContext.")); err != nil {
+		return nil, err
+	}
+	if instrumentation.holdTime, err = meter.Float64Histogram("grpcpool.hold", metric.WithUnit("s"),
+		metric.WithDescription("Time a connection is held between Get and Put.")); err != nil {
+		return nil, err
+	}
+	if instrumentation.closeDuration, err = meter.Float64Histogram("grpcpool.close.duration", metric.WithUnit("s"),
+		metric.WithDescription("Time spent closing an evicted connection.")); err != nil {
+		return nil, err
+	}
+	if instrumentation.closeBacklog, err = meter.Int64UpDownCounter("grpcpool.close.backlog",
+		metric.WithDescription("Number of evicted connections waiting to be closed.")); err != nil {
+		return nil, err
+	}
+
+	instrumentation.endpointSet = metric.WithAttributeSet(attribute.NewSet(instrumentation.endpoint))
+	instrumentation.resultSets = make(map[string]metric.MeasurementOption)
+	for _, result := range []string{"success", "refused", "timeout", "error", "empty", "limited", "full", "close", "old", "idle", "misuse"} {
+		instrumentation.resultSets[result] = metric.WithAttributeSet(attribute.NewSet(instrumentation.endpoint, ResultKey.String(result)))
+	}
+	instrumentation.reasonSets = make(map[grpcpool.EvictReason]metric.MeasurementOption)
+	for _, reason := range []grpcpool.EvictReason{grpcpool.EVICT_HEALTH, grpcpool.EVICT_BROKEN, grpcpool.EVICT_DISCONNECTED} {
+		instrumentation.reasonSets[reason] = metric.WithAttributeSet(attribute.NewSet(instrumentation.endpoint, ReasonKey.String(reason.String())))
+	}
+	return instrumentation, nil
+}
+
+func (this *Instrumentation) add(counter metric.Int64Counter, result string) {
+	counter.Add(context.Background(), 1, this.resultSets[result])
+}
+
+// MetricObserver
+
+func (this *Instrumentation) DecUsed() int32 {
+	if this.next != nil {
+		this.next.DecUsed()
+	}
+	this.used.Add(context.Background(), -1, this.endpointSet)
+	return atomic.AddInt32(&this.metric.Used, -1)
+}
+
+func (this *Instrumentation) DecIdle() int32 {
+	if this.next != nil {
+		this.next.DecIdle()
+	}
+	this.idle.Add(context.Background(), -1, this.endpointSet)
+	return atomic.AddInt32(&this.metric.Idle, -1)
+}
+
+func (this *Instrumentation) IncUsed() int32 {
+	if this.next != nil {
+		this.next.IncUsed()
+	}
+	this.used.Add(context.Background(), 1, this.endpointSet)
+	return atomic.AddInt32(&this.metric.Used, 1)
+}
+
+func (this *Instrumentation) IncIdle() int32 {
+	if this.next != nil {
+		this.next.IncIdle()
+	}
+	this.idle.Add(context.Background(), 1, this.endpointSet)
+	return atomic.AddInt32(&this.metric.Idle, 1)
+}
+
+func (this *Instrumentation) IncDialRefused() int32 {
+	if this.next != nil {
+		this.next.IncDialRefused()
+	}
+	this.add(this.dial, "refused")
+	return atomic.AddInt32(&this.metric.DialRefused, 1)
+}
+
+func (this *Instrumentation) IncDialTimeout() int32 {
+	if this.next != nil {
+		this.next.IncDialTimeout()
+	}
+	this.add(this.dial, "timeout")
+	return atomic.AddInt32(&this.metric.DialTimeout, 1)
+}
+
+func (this *Instrumentation) IncDialSuccess() int32 {
+	if this.next != nil {
+		this.next.IncDialSuccess()
+	}
+	this.add(this.dial, "success")
+	return atomic.AddInt32(&this.metric.DialSuccess, 1)
+}
+
+func (this *Instrumentation) IncDialError() int32 {
+	if this.next != nil {
+		this.next.IncDialError()
+	}
+	this.add(this.dial, "error")
+	return atomic.AddInt32(&this.metric.DialError, 1)
+}
+
+func (this *Instrumentation) IncGetSuccess() int32 {
+	if this.next != nil {
+		this.next.IncGetSuccess()
+	}
+	this.add(this.get, "success")
+	return atomic.AddInt32(&this.metric.GetSuccess, 1)
+}
+
+func (this *Instrumentation) IncGetEmpty() int32 {
+	if this.next != nil {
+		this.next.IncGetEmpty()
+	}
+	this.add(this.get, "empty")
+	return atomic.AddInt32(&this.metric.GetEmpty, 1)
+}
+
+func (this *Instrumentation) IncGetLimited() int32 {
+	if next, ok := this.next.(grpcpool.LimitObserver); ok {
+		next.IncGetLimited()
+	}
+	this.add(this.get, "limited")
+	return atomic.AddInt32(&this.metric.GetLimited, 1)
+}
+
+func (this *Instrumentation) IncPutSuccess() int32 {
+	if this.next != nil {
+		this.next.IncPutSuccess()
+	}
+	this.add(this.put, "success")
+	return atomic.AddInt32(&this.metric.PutSuccess, 1)
+}
+
+func (this *Instrumentation) IncPutFull() int32 {
+	if this.next != nil {
+		this.next.IncPutFull()
+	}
+	this.add(this.put, "full")
+	return atomic.AddInt32(&this.metric.PutFull, 1)
+}
+
+func (this *Instrumentation) IncPutClose() int32 {
+	if this.next != nil {
+		this.next.IncPutClose()
+	}
+	this.add(this.put, "close")
+	return atomic.AddInt32(&this.metric.PutClose, 1)
+}
+
+func (this *Instrumentation) IncPutOld() int32 {
+	if this.next != nil {
+		this.next.IncPutOld()
+	}
+	this.add(this.put, "old")
+	return atomic.AddInt32(&this.metric.PutOld, 1)
+}
+
+func (this *Instrumentation) IncPutIdle() int32 {
+	if this.next != nil {
+		this.next.IncPutIdle()
+	}
+	this.add(this.put, "idle")
+	return atomic.AddInt32(&this.metric.PutIdle, 1)
+}
+
+func (this *Instrumentation) IncPutMisuse() int32 {
+	if next, ok := this.next.(grpcpool.MisuseObserver); ok {
+		next.IncPutMisuse()
+	}
+	this.add(this.put, "misuse")
+	return atomic.AddInt32(&this.metric.PutMisuse, 1)
+}
+
+// EvictObserver
+
+func (this *Instrumentation) IncEvictHealth() int32 {
+	if next, ok := this.next.(grpcpool.EvictObserver); ok {
+		next.IncEvictHealth()
+	}
+	this.evict.Add(context.Background(), 1, this.reasonSets[grpcpool.EVICT_HEALTH])
+	return atomic.AddInt32(&this.metric.EvictHealth, 1)
+}
+
+func (this *Instrumentation) IncEvictBroken() int32 {
+	if next, ok := this.next.(grpcpool.EvictObserver); ok {
+		next.IncEvictBroken()
+	}
+	this.evict.Add(context.Background(), 1, this.reasonSets[grpcpool.EVICT_BROKEN])
+	return atomic.AddInt32(&this.metric.EvictBroken, 1)
+}
+
+func (this *Instrumentation) IncEvictDisconnected() int32 {
+	if next, ok := this.next.(grpcpool.EvictObserver); ok {
+		next.IncEvictDisconnected()
+	}
+	this.evict.Add(context.Background(), 1, this.reasonSets[grpcpool.EVICT_DISCONNECTED])
+	return atomic.AddInt32(&this.metric.EvictDisconnected, 1)
+}
+
+// LatencyObserver
+
+func (this *Instrumentation) ObserveGetWait(d time.Duration) {
+	if next, ok := this.next.(grpcpool.LatencyObserver); ok {
+		next.ObserveGetWait(d)
+	}
+	this.getWait.Record(context.Background(), d.Seconds(), this.endpointSet)
+}
+
+func (this *Instrumentation) ObserveDial(d time.Duration) {
+	if next, ok := this.next.(grpcpool.LatencyObserver); ok {
+		next.ObserveDial(d)
+	}
+	this.dialDuration.Record(context.Background(), d.Seconds(), this.endpointSet)
+}
+
+func (this *Instrumentation) ObserveHold(d time.Duration) {
+	if next, ok := this.next.(grpcpool.LatencyObserver); ok {
+		next.ObserveHold(d)
+	}
+	this.holdTime.Record(context.Background(), d.Seconds(), this.endpointSet)
+}
+
+// CloseObserver
+
+func (this *Instrumentation) ObserveClose(d time.Duration) {
+	if next, ok := this.next.(grpcpool.CloseObserver); ok {
+		next.ObserveClose(d)
+	}
+	this.closeDuration.Record(context.Background(), d.Seconds(), this.endpointSet)
+}
+
+func (this *Instrumentation) AddCloseBacklog(delta int32) {
+	if next, ok := this.next.(grpcpool.CloseObserver); ok {
+		next.AddCloseBacklog(delta)
+	}
+	atomic.AddInt32(&this.metric.CloseBacklog, delta)
+	this.closeBacklog.Add(context.Background(), int64(delta), this.endpointSet)
+}
+
+// 取自接入前池使用的观察者（实现了 grpcpool.MetricSnapshotter 时），供池的成员函数 Stats 使用
+func (this *Instrumentation) Snapshot(reset bool) grpcpool.Metric {
+	if next, ok := this.next.(grpcpool.MetricSnapshotter); ok {
+		return next.Snapshot(reset)
+	}
+	return grpcpool.Metric{}
+}
+
+// Tracer
+
+func (this *Instrumentation) TraceGet(ctx context.Context, endpoint string) (context.Context, func(dialed bool, wait time.Duration, errcode uint32, err error)) {
+	ctx, span := this.tracer.Start(ctx, "grpcpool.Get",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(EndpointKey.String(endpoint)))
+	return ctx, func(dialed bool, wait time.Duration, errcode uint32, err error) {
+		span.SetAttributes(
+			DialedKey.Bool(dialed),
+			WaitKey.Float64(wait.Seconds()),
+			ErrcodeKey.Int64(int64(errcode)))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+func (this *Instrumentation) TraceDial(ctx context.Context, endpoint string) (context.Context, func(err error)) {
+	ctx, span := this.tracer.Start(ctx, "grpcpool.Dial",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(EndpointKey.String(endpoint)))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,229 @@
+package grpcpoolotel_test
+
+import (
+	"context"
+	"testing"
+)
+import (
+	"github.com/eyjian/grpcpool"
+	"github.com/eyjian/grpcpool/grpcpoolotel"
+	"github.com/eyjian/grpcpool/grpcpooltest"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// 取得名为 name 的指标中 grpcpool.result 为 result（为空时不区分）的值，直方图取样本数
+func value(t *testing.T, rm *metricdata.ResourceMetrics, name, result string) int64 {
+	t.Helper()
+	var sum int64
+	found := false
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			found = true
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, point := range data.DataPoints {
+					if v, ok := point.Attributes.Value(grpcpoolotel.ResultKey); result == "" || (ok && v.AsString() == result) {
+						sum += point.Value
+					}
+				}
+			case metricdata.Histogram[float64]:
+				for _, point := range data.DataPoints {
+					sum += int64(point.Count)
+				}
+			default:
+				t.Fatalf("%s: unexpected data type %T", name, m.Data)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("metric %s not found", name)
+	}
+	return sum
+}
+
+// 创建服务端和连接它的池（initSize 0、idleSize 2、peakSize 4），池使用独立的 DefaultMetricObserver，测试结束时关闭池和服务端
+func newPool(t *testing.T) (*grpcpool.GRPCPool, *grpcpool.DefaultMetricObserver) {
+	server := grpcpooltest.NewServer()
+	pool := server.NewPool(0, 2, 4)
+	mo := new(grpcpool.DefaultMetricObserver)
+	pool.SetMetricObserver(mo)
+	t.Cleanup(func() {
+		pool.Close()
+		server.Close()
+	})
+	return pool, mo
+}
+
+// 取还 n 次：第一次新拨号，之后取到的都是空闲的，
+// 即一次拨号、n-1 次取池成功（新拨号的不计为取池成功）、n 次还池成功
+func getPut(t *testing.T, pool *grpcpool.GRPCPool, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		conn, errcode, err := pool.Get(context.Background())
+		if err != nil {
+			t.Fatalf("Get: errcode %d, %v", errcode, err)
+		}
+		if errcode, err := pool.Put(conn); err != nil {
+			t.Fatalf("Put: errcode %d, %v", errcode, err)
+		}
+	}
+}
+
+// 检查被包装的 mo 仍收到 getPut(n) 的全部计数
+func checkForwarded(t *testing.T, pool *grpcpool.GRPCPool, mo *grpcpool.DefaultMetricObserver, n int) {
+	t.Helper()
+	metric := mo.Snapshot(false)
+	if metric.DialSuccess != 1 || metric.GetSuccess != int32(n-1) || metric.PutSuccess != int32(n) {
+		t.Errorf("wrapped observer: dial %d, get %d, put %d, want 1, %d, %d", metric.DialSuccess, metric.GetSuccess, metric.PutSuccess, n-1, n)
+	}
+	if stats := pool.Stats().Metric; stats.PutSuccess != int32(n) {
+		t.Errorf("stats PutSuccess = %d, want %d", stats.PutSuccess, n)
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	pool, mo := newPool(t)
+	reader := sdkmetric.NewManualReader()
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	if _, err := grpcpoolotel.Instrument(pool, meterProvider, tracerProvider); err != nil {
+		t.Fatalf("Instrument: %v", err)
+	}
+
+	getPut(t, pool, 2)
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	for _, tc := range []struct {
+		name, result string
+		want         int64
+	}{
+		{"grpcpool.used", "", 0},
+		{"grpcpool.idle", "", 1},
+		{"grpcpool.dial", "success", 1},
+		{"grpcpool.dial", "error", 0},
+		{"grpcpool.get", "success", 1},
+		{"grpcpool.get", "empty", 0},
+		{"grpcpool.put", "success", 2},
+		{"grpcpool.put", "full", 0},
+		{"grpcpool.get.wait", "", 2},
+		{"grpcpool.dial.duration", "", 1},
+		{"grpcpool.hold", "", 2},
+	} {
+		if got := value(t, &rm, tc.name, tc.result); got != tc.want {
+			t.Errorf("%s{result=%q} = %d, want %d", tc.name, tc.result, got, tc.want)
+		}
+	}
+
+	spans := make(map[string]int)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()]++
+	}
+	if spans["grpcpool.Get"] != 2 || spans["grpcpool.Dial"] != 1 {
+		t.Errorf("spans = %v, want 2 grpcpool.Get and 1 grpcpool.Dial", spans)
+	}
+
+	checkForwarded(t, pool, mo, 2)
+
+	// 剔除数按原因记录，并转给被包装的观察者
+	eo, ok := pool.GetMetricObserver().(grpcpool.EvictObserver)
+	if !ok {
+		t.Fatalf("observer %T does not implement EvictObserver", pool.GetMetricObserver())
+	}
+	eo.IncEvictHealth()
+	eo.IncEvictDisconnected()
+	eo.IncEvictDisconnected()
+	rm = metricdata.ResourceMetrics{}
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	for _, tc := range []struct {
+		reason string
+		want   int64
+	}{
+		{"health", 1},
+		{"broken", 0},
+		{"disconnected", 2},
+	} {
+		if got := reasonValue(&rm, tc.reason); got != tc.want {
+			t.Errorf("grpcpool.evict{reason=%q} = %d, want %d", tc.reason, got, tc.want)
+		}
+	}
+	if metric := mo.Snapshot(false); metric.EvictHealth != 1 || metric.EvictDisconnected != 2 {
+		t.Errorf("wrapped observer: EvictHealth %d, EvictDisconnected %d, want 1, 2", metric.EvictHealth, metric.EvictDisconnected)
+	}
+}
+
+// 取得 grpcpool.evict 中 grpcpool.reason 为 reason 的值
+func reasonValue(rm *metricdata.ResourceMetrics, reason string) int64 {
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if data, ok := m.Data.(metricdata.Sum[int64]); ok && m.Name == "grpcpool.evict" {
+				for _, point := range data.DataPoints {
+					if v, ok := point.Attributes.Value(grpcpoolotel.ReasonKey); ok && v.AsString() == reason {
+						return point.Value
+					}
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// Close 恢复接入前的观察者和跟踪器，之后的取还不再记录
+func TestInstrumentClose(t *testing.T) {
+	pool, mo := newPool(t)
+	reader := sdkmetric.NewManualReader()
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	instrumentation, err := grpcpoolotel.Instrument(pool, meterProvider, nil)
+	if err != nil {
+		t.Fatalf("Instrument: %v", err)
+	}
+	getPut(t, pool, 1)
+	instrumentation.Close()
+	if pool.GetMetricObserver() != grpcpool.MetricObserver(mo) {
+		t.Errorf("observer after Close = %T, want the one set before Instrument", pool.GetMetricObserver())
+	}
+	if tracer := pool.GetTracer(); tracer != nil {
+		t.Errorf("tracer after Close = %T, want nil", tracer)
+	}
+
+	getPut(t, pool, 1)
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if got := value(t, &rm, "grpcpool.put", "success"); got != 1 {
+		t.Errorf("grpcpool.put{result=\"success\"} = %d, want 1", got)
+	}
+	if put := mo.Snapshot(false).PutSuccess; put != 2 {
+		t.Errorf("wrapped observer PutSuccess = %d, want 2", put)
+	}
+}
+
+// 接入后池的观察者又被包装的，Close 不恢复观察者，以免把外层的一并去掉
+func TestInstrumentCloseWrapped(t *testing.T) {
+	pool, _ := newPool(t)
+	instrumentation, err := grpcpoolotel.Instrument(pool, nil, nil)
+	if err != nil {
+		t.Fatalf("Instrument: %v", err)
+	}
+	outer := struct{ grpcpool.MetricObserver }{pool.GetMetricObserver()}
+	pool.SetMetricObserver(&outer)
+	instrumentation.Close()
+	if pool.GetMetricObserver() != grpcpool.MetricObserver(&outer) {
+		t.Errorf("observer after Close = %T, want the one wrapped after Instrument", pool.GetMetricObserver())
+	}
+	if tracer := pool.GetTracer(); tracer != nil {
+		t.Errorf("tracer after Close = %T, want nil", tracer)
+	}
+}
@@ -0,0 +1,450 @@
+// Package grpcpoolprom 将 grpcpool 连接池的度量数据导出为 Prometheus 指标。
+//
+// 为避免 grpcpool 本身依赖 Prometheus，本包是一个独立的 module。
+//
+// 使用方法：
+//
+//	collector := grpcpoolprom.NewCollector("myapp")
+//	if err := collector.Register(gRPCPool); err != nil { // 可对使用中的池调用，包装池原有的观察者，Unregister 后恢复
+//		...
+//	}
+//	prometheus.MustRegister(collector)
+//
+// 导出的指标（均带 endpoint 标签）：
+// 1) 仪表：grpcpool_used、grpcpool_idle、grpcpool_init_size、grpcpool_idle_size、grpcpool_peak_size；
+// 2) 计数器：grpcpool_dial_total、grpcpool_get_total、grpcpool_put_total（以 result 标签区分结果）；
+// 3) 直方图：grpcpool_get_wait_seconds、grpcpool_dial_duration_seconds、grpcpool_hold_seconds。
+package grpcpoolprom
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+import (
+	"github.com/eyjian/grpcpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 实现了 prometheus.Collector
+type Collector struct {
+	mutex     sync.RWMutex
+	observers []*observer
+
+	used         *prometheus.Desc
+	idle         *prometheus.Desc
+	initSize     *prometheus.Desc
+	idleSize     *prometheus.Desc
+	peakSize     *prometheus.Desc
+	closeBacklog *prometheus.Desc
+	dial         *prometheus.Desc
+	get          *prometheus.Desc
+	put          *prometheus.Desc
+	evict        *prometheus.Desc
+
+	getWait       *prometheus.HistogramVec
+	dialDuration  *prometheus.HistogramVec
+	holdTime      *prometheus.HistogramVec
+	closeDuration *prometheus.HistogramVec
+}
+
+// 对接口 grpcpool.MetricObserver、grpcpool.LatencyObserver、grpcpool.LimitObserver、grpcpool.MisuseObserver、grpcpool.CloseObserver 和 grpcpool.EvictObserver 的实现，每个池一个，
+// 计数只增不减（Prometheus 计数器的要求），不提供 Zero 操作。
+// 所有调用同时转给注册前池使用的观察者，因此原有的统计（如 Stats 和调试页面）不受影响。
+type observer struct {
+	// 导出的计数器用 uint64，长期运行的池的 int32 计数会回绕为负数，Prometheus 会把它视为计数器被重置。
+	// 放在最前面以保证 32 位平台上 64 位原子操作的对齐。
+	// 接口要求返回 int32，返回值是截断后的计数（池不使用返回值）
+	counters counters
+
+	pool *grpcpool.GRPCPool
+	next grpcpool.MetricObserver // 注册前池使用的观察者（包括全局的），可能为 nil
+	prev grpcpool.MetricObserver // 注册前用 SetMetricObserver 为池设置的观察者，未设置过时为 nil，Unregister 时恢复
+
+	getWait       prometheus.Observer
+	dialDuration  prometheus.Observer
+	holdTime      prometheus.Observer
+	closeDuration prometheus.Observer
+}
+
+// observer 的计数器，字段与 grpcpool.Metric 的同名计数对应（Used、Idle 和 CloseBacklog 在 Collect 时取自池）
+type counters struct {
+	dialRefused       uint64
+	dialTimeout       uint64
+	dialSuccess       uint64
+	dialError         uint64
+	getSuccess        uint64
+	getEmpty          uint64
+	getLimited        uint64
+	putSuccess        uint64
+	putFull           uint64
+	putClose          uint64
+	putOld            uint64
+	putIdle           uint64
+	putMisuse         uint64
+	evictHealth       uint64
+	evictBroken       uint64
+	evictDisconnected uint64
+}
+
+// 创建 Collector，namespace 为指标名前缀，可为空
+func NewCollector(namespace string) *Collector {
+	labels := []string{"endpoint"}
+	resultLabels := []string{"endpoint", "result"}
+	collector := new(Collector)
+	collector.used = prometheus.NewDesc(prometheus.BuildFQName(namespace, "grpcpool", "used"),
+		"Number of connections in use (not in the pool).", labels, nil)
+	collector.idle = prometheus.NewDesc(prometheus.BuildFQName(namespace, "grpcpool", "idle"),
+		"Number of idle connections in the pool.", labels, nil)
+	collector.initSize = prometheus.NewDesc(prometheus.BuildFQName(namespace, "grpcpool", "init_size"),
+		"Number of connections kept permanently.", labels, nil)
+	collector.idleSize = prometheus.NewDesc(prometheus.BuildFQName(namespace, "grpcpool", "idle_size"),
+		"Number of connections kept for the idle timeout.", labels, nil)
+	collector.peakSize = prometheus.NewDesc(prometheus.BuildFQName(namespace, "grpcpool", "peak_size"),
+		"Maximum number of connections.", labels, nil)
+	collector.closeBacklog = prometheus.NewDesc(prometheus.BuildFQName(namespace, "grpcpool", "close_backlog"),
+		"Number of evicted connections waiting to be closed.", labels, nil)
+	collector.dial = prometheus.NewDesc(prometheus.BuildFQName(namespace, "grpcpool", "dial_total"),
+		"Number of gRPC dials by result (success, refused, timeout, error).", resultLabels, nil)
+	collector.get = prometheus.NewDesc(prometheus.BuildFQName(namespace, "grpcpool", "get_total"),
+		"Number of Get calls by result (success, empty, limited).", resultLabels, nil)
+	collector.put = prometheus.NewDesc(prometheus.BuildFQName(namespace, "grpcpool", "put_total"),
+		"Number of Put calls by result (success, full, close, old, idle, misuse).", resultLabels, nil)
+	collector.evict = prometheus.NewDesc(prometheus.BuildFQName(namespace, "grpcpool", "evict_total"),
+		"Number of connections evicted outside Put accounting by reason (health, broken, disconnected).", []string{"endpoint", "reason"}, nil)
+	collector.getWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpcpool",
+		Name:      "get_wait_seconds",
+		Help:      "Time spent in Get, including dialing a new connection.",
+		Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+	}, labels)
+	collector.dialDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpcpool",
+		Name:      "dial_duration_seconds",
+		Help:      "Time spent in grpc.DialContext.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, labels)
+	collector.holdTime = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpcpool",
+		Name:      "hold_seconds",
+		Help:      "Time a connection is held between Get and Put.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, labels)
+	collector.closeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "grpcpool",
+		Name:      "close_duration_seconds",
+		Help:      "Time spent closing an evicted connection.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, labels)
+	return collector
+}
+
+// 开始采集池的度量数据，会用池的成员函数 CompareAndSwapMetricObserver 包装池原来的观察者（而不是替换），
+// 可对使用中的池调用（注册前的计数不被采集）。
+// 指标以 endpoint 区分各池，同一 endpoint 的池已注册到本 Collector 时返回错误，否则 Gather 时会因重复的指标而失败，
+// 同一 endpoint 的多个池应分别注册到不同 namespace 的 Collector。
+func (this *Collector) Register(pool *grpcpool.GRPCPool) error {
+	endpoint := pool.GetEndpoint()
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for _, obs := range this.observers {
+		if obs.pool.GetEndpoint() == endpoint {
+			return fmt.Errorf("grpcpoolprom: a pool for %s is already registered", endpoint)
+		}
+	}
+
+	obs := &observer{
+		pool:          pool,
+		getWait:       this.getWait.WithLabelValues(endpoint),
+		dialDuration:  this.dialDuration.WithLabelValues(endpoint),
+		holdTime:      this.holdTime.WithLabelValues(endpoint),
+		closeDuration: this.closeDuration.WithLabelValues(endpoint),
+	}
+	// 读取原来的观察者和安装 obs 须是一步，否则其间被其它协程设置的观察者会从链中丢失，
+	// 因此用 CompareAndSwapMetricObserver 安装，原来的观察者已变化时重试
+	for {
+		next := pool.GetMetricObserver()
+		obs.next = next
+		if pool.CompareAndSwapMetricObserver(next, obs) {
+			obs.prev = next
+			break
+		}
+		// 未用 SetMetricObserver 设置过时 GetMetricObserver 返回的是全局的
+		if pool.CompareAndSwapMetricObserver(nil, obs) {
+			obs.prev = nil
+			break
+		}
+	}
+	this.observers = append(this.observers, obs)
+	return nil
+}
+
+// 停止采集池的度量数据，池恢复使用注册前的观察者（注册前未调用过 SetMetricObserver 的，恢复使用全局的），
+// 注册后池的观察者又被替换或包装过的（如 grpcpoolotel 的 Instrument）不恢复
+func (this *Collector) Unregister(pool *grpcpool.GRPCPool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for i, obs := range this.observers {
+		if obs.pool == pool {
+			// 之后又有观察者包装在外层时不恢复，以免把它一并去掉（obs 仍在其中转发计数，但不再被采集）
+			pool.CompareAndSwapMetricObserver(obs, obs.prev)
+			this.observers = append(this.observers[:i], this.observers[i+1:]...)
+			endpoint := pool.GetEndpoint()
+			this.getWait.DeleteLabelValues(endpoint)
+			this.dialDuration.DeleteLabelValues(endpoint)
+			this.holdTime.DeleteLabelValues(endpoint)
+			this.closeDuration.DeleteLabelValues(endpoint)
+			break
+		}
+	}
+}
+
+func (this *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- this.used
+	ch <- this.idle
+	ch <- this.initSize
+	ch <- this.idleSize
+	ch <- this.peakSize
+	ch <- this.closeBacklog
+	ch <- this.dial
+	ch <- this.get
+	ch <- this.put
+	ch <- this.evict
+	this.getWait.Describe(ch)
+	this.dialDuration.Describe(ch)
+	this.holdTime.Describe(ch)
+	this.closeDuration.Describe(ch)
+}
+
+func (this *Collector) Collect(ch chan<- prometheus.Metric) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	for _, obs := range this.observers {
+		pool := obs.pool
+		endpoint := pool.GetEndpoint()
+		gauge := func(desc *prometheus.Desc, value int32) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(value), endpoint)
+		}
+		counter := func(desc *prometheus.Desc, result string, value *uint64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(atomic.LoadUint64(value)), endpoint, result)
+		}
+
+		gauge(this.used, pool.GetUsed())
+		gauge(this.idle, pool.GetIdle())
+		gauge(this.initSize, pool.GetInitSize())
+		gauge(this.idleSize, pool.GetIdleSize())
+		gauge(this.peakSize, pool.GetPeakSize())
+		gauge(this.closeBacklog, pool.GetCloseBacklog())
+		counter(this.dial, "success", &obs.counters.dialSuccess)
+		counter(this.dial, "refused", &obs.counters.dialRefused)
+		counter(this.dial, "timeout", &obs.counters.dialTimeout)
+		counter(this.dial, "error", &obs.counters.dialError)
+		counter(this.get, "success", &obs.counters.getSuccess)
+		counter(this.get, "empty", &obs.counters.getEmpty)
+		counter(this.get, "limited", &obs.counters.getLimited)
+		counter(this.put, "success", &obs.counters.putSuccess)
+		counter(this.put, "full", &obs.counters.putFull)
+		counter(this.put, "close", &obs.counters.putClose)
+		counter(this.put, "old", &obs.counters.putOld)
+		counter(this.put, "idle", &obs.counters.putIdle)
+		counter(this.put, "misuse", &obs.counters.putMisuse)
+		counter(this.evict, "health", &obs.counters.evictHealth)
+		counter(this.evict, "broken", &obs.counters.evictBroken)
+		counter(this.evict, "disconnected", &obs.counters.evictDisconnected)
+	}
+	this.getWait.Collect(ch)
+	this.dialDuration.Collect(ch)
+	this.holdTime.Collect(ch)
+	this.closeDuration.Collect(ch)
+}
+
+// observer
+
+// used 和 idle 在 Collect 时取自池，只转给注册前的观察者
+func (this *observer) DecUsed() int32 {
+	if this.next != nil {
+		return this.next.DecUsed()
+	}
+	return 0
+}
+
+func (this *observer) DecIdle() int32 {
+	if this.next != nil {
+		return this.next.DecIdle()
+	}
+	return 0
+}
+
+func (this *observer) IncUsed() int32 {
+	if this.next != nil {
+		return this.next.IncUsed()
+	}
+	return 0
+}
+
+func (this *observer) IncIdle() int32 {
+	if this.next != nil {
+		return this.next.IncIdle()
+	}
+	return 0
+}
+
+func (this *observer) IncDialRefused() int32 {
+	if this.next != nil {
+		this.next.IncDialRefused()
+	}
+	return int32(atomic.AddUint64(&this.counters.dialRefused, 1))
+}
+
+func (this *observer) IncDialTimeout() int32 {
+	if this.next != nil {
+		this.next.IncDialTimeout()
+	}
+	return int32(atomic.AddUint64(&this.counters.dialTimeout, 1))
+}
+
+func (this *observer) IncDialSuccess() int32 {
+	if this.next != nil {
+		this.next.IncDialSuccess()
+	}
+	return int32(atomic.AddUint64(&this.counters.dialSuccess, 1))
+}
+
+func (this *observer) IncDialError() int32 {
+	if this.next != nil {
+		this.next.IncDialError()
+	}
+	return int32(atomic.AddUint64(&this.counters.dialError, 1))
+}
+
+func (this *observer) IncGetSuccess() int32 {
+	if this.next != nil {
+		this.next.IncGetSuccess()
+	}
+	return int32(atomic.AddUint64(&this.counters.getSuccess, 1))
+}
+
+func (this *observer) IncGetEmpty() int32 {
+	if this.next != nil {
+		this.next.IncGetEmpty()
+	}
+	return int32(atomic.AddUint64(&this.counters.getEmpty, 1))
+}
+
+func (this *observer) IncGetLimited() int32 {
+	if next, ok := this.next.(grpcpool.LimitObserver); ok {
+		next.IncGetLimited()
+	}
+	return int32(atomic.AddUint64(&this.counters.getLimited, 1))
+}
+
+func (this *observer) IncPutSuccess() int32 {
+	if this.next != nil {
+		this.next.IncPutSuccess()
+	}
+	return int32(atomic.AddUint64(&this.counters.putSuccess, 1))
+}
+
+func (this *observer) IncPutFull() int32 {
+	if this.next != nil {
+		this.next.IncPutFull()
+	}
+	return int32(atomic.AddUint64(&this.counters.putFull, 1))
+}
+
+func (this *observer) IncPutClose() int32 {
+	if this.next != nil {
+		this.next.IncPutClose()
+	}
+	return int32(atomic.AddUint64(&this.counters.putClose, 1))
+}
+
+func (this *observer) IncPutOld() int32 {
+	if this.next != nil {
+		this.next.IncPutOld()
+	}
+	return int32(atomic.AddUint64(&this.counters.putOld, 1))
+}
+
+func (this *observer) IncPutIdle() int32 {
+	if this.next != nil {
+		this.next.IncPutIdle()
+	}
+	return int32(atomic.AddUint64(&this.counters.putIdle, 1))
+}
+
+func (this *observer) IncPutMisuse() int32 {
+	if next, ok := this.next.(grpcpool.MisuseObserver); ok {
+		next.IncPutMisuse()
+	}
+	return int32(atomic.AddUint64(&this.counters.putMisuse, 1))
+}
+
+func (this *observer) IncEvictHealth() int32 {
+	if next, ok := this.next.(grpcpool.EvictObserver); ok {
+		next.IncEvictHealth()
+	}
+	return int32(atomic.AddUint64(&this.counters.evictHealth, 1))
+}
+
+func (this *observer) IncEvictBroken() int32 {
+	if next, ok := this.next.(grpcpool.EvictObserver); ok {
+		next.IncEvictBroken()
+	}
+	return int32(atomic.AddUint64(&this.counters.evictBroken, 1))
+}
+
+func (this *observer) IncEvictDisconnected() int32 {
+	if next, ok := this.next.(grpcpool.EvictObserver); ok {
+		next.IncEvictDisconnected()
+	}
+	return int32(atomic.AddUint64(&this.counters.evictDisconnected, 1))
+}
+
+func (this *observer) ObserveGetWait(d time.Duration) {
+	if next, ok := this.next.(grpcpool.LatencyObserver); ok {
+		next.ObserveGetWait(d)
+	}
+	this.getWait.Observe(d.Seconds())
+}
+
+func (this *observer) ObserveDial(d time.Duration) {
+	if next, ok := this.next.(grpcpool.LatencyObserver); ok {
+		next.ObserveDial(d)
+	}
+	this.dialDuration.Observe(d.Seconds())
+}
+
+func (this *observer) ObserveHold(d time.Duration) {
+	if next, ok := this.next.(grpcpool.LatencyObserver); ok {
+		next.ObserveHold(d)
+	}
+	this.holdTime.Observe(d.Seconds())
+}
+
+func (this *observer) ObserveClose(d time.Duration) {
+	if next, ok := this.next.(grpcpool.CloseObserver); ok {
+		next.ObserveClose(d)
+	}
+	this.closeDuration.Observe(d.Seconds())
+}
+
+// 等待关闭的连接数在 Collect 时取自池
+func (this *observer) AddCloseBacklog(delta int32) {
+	if next, ok := this.next.(grpcpool.CloseObserver); ok {
+		next.AddCloseBacklog(delta)
+	}
+}
+
+// 取自注册前池使用的观察者（实现了 grpcpool.MetricSnapshotter 时），供池的成员函数 Stats 使用
+func (this *observer) Snapshot(reset bool) grpcpool.Metric {
+	if next, ok := this.next.(grpcpool.MetricSnapshotter); ok {
+		return next.Snapshot(reset)
+	}
+	return grpcpool.Metric{}
+}
@@ -0,0 +1,297 @@
+package grpcpoolprom_test
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"testing"
+)
+import (
+	"github.com/eyjian/grpcpool"
+	"github.com/eyjian/grpcpool/grpcpoolprom"
+	"github.com/eyjian/grpcpool/grpcpooltest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// 一次拨号、一次取池，两次还池后各项指标的值
+const expected = `
+# HELP test_grpcpool_used Number of connections in use (not in the pool).
+# TYPE test_grpcpool_used gauge
+test_grpcpool_used{endpoint="bufconn"} 0
+# HELP test_grpcpool_idle Number of idle connections in the pool.
+# TYPE test_grpcpool_idle gauge
+test_grpcpool_idle{endpoint="bufconn"} 1
+# HELP test_grpcpool_peak_size Maximum number of connections.
+# TYPE test_grpcpool_peak_size gauge
+test_grpcpool_peak_size{endpoint="bufconn"} 4
+# HELP test_grpcpool_dial_total Number of gRPC dials by result (success, refused, timeout, error).
+# TYPE test_grpcpool_dial_total counter
+test_grpcpool_dial_total{endpoint="bufconn",result="error"} 0
+test_grpcpool_dial_total{endpoint="bufconn",result="refused"} 0
+test_grpcpool_dial_total{endpoint="bufconn",result="success"} 1
+test_grpcpool_dial_total{endpoint="bufconn",result="timeout"} 0
+# HELP test_grpcpool_get_total Number of Get calls by result (success, empty, limited).
+# TYPE test_grpcpool_get_total counter
+test_grpcpool_get_total{endpoint="bufconn",result="empty"} 0
+test_grpcpool_get_total{endpoint="bufconn",result="limited"} 0
+test_grpcpool_get_total{endpoint="bufconn",result="success"} 1
+# HELP test_grpcpool_put_total Number of Put calls by result (success, full, close, old, idle, misuse).
+# TYPE test_grpcpool_put_total counter
+test_grpcpool_put_total{endpoint="bufconn",result="close"} 0
+test_grpcpool_put_total{endpoint="bufconn",result="full"} 0
+test_grpcpool_put_total{endpoint="bufconn",result="idle"} 0
+test_grpcpool_put_total{endpoint="bufconn",result="misuse"} 0
+test_grpcpool_put_total{endpoint="bufconn",result="old"} 0
+test_grpcpool_put_total{endpoint="bufconn",result="success"} 2
+# HELP test_grpcpool_evict_total Number of connections evicted outside Put accounting by reason (health, broken, disconnected).
+# TYPE test_grpcpool_evict_total counter
+test_grpcpool_evict_total{endpoint="bufconn",reason="broken"} 0
+test_grpcpool_evict_total{endpoint="bufconn",reason="disconnected"} 0
+test_grpcpool_evict_total{endpoint="bufconn",reason="health"} 0
+`
+
+// 创建服务端和连接它的池（initSize 0、idleSize 2、peakSize 4），池使用独立的 DefaultMetricObserver，测试结束时关闭池和服务端
+func newPool(t *testing.T) (*grpcpool.GRPCPool, *grpcpool.DefaultMetricObserver) {
+	server := grpcpooltest.NewServer()
+	pool := server.NewPool(0, 2, 4)
+	mo := new(grpcpool.DefaultMetricObserver)
+	pool.SetMetricObserver(mo)
+	t.Cleanup(func() {
+		pool.Close()
+		server.Close()
+	})
+	return pool, mo
+}
+
+// 取还 n 次：第一次新拨号，之后取到的都是空闲的，
+// 即一次拨号、n-1 次取池成功（新拨号的不计为取池成功）、n 次还池成功
+func getPut(t *testing.T, pool *grpcpool.GRPCPool, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		conn, errcode, err := pool.Get(context.Background())
+		if err != nil {
+			t.Fatalf("Get: errcode %d, %v", errcode, err)
+		}
+		if errcode, err := pool.Put(conn); err != nil {
+			t.Fatalf("Put: errcode %d, %v", errcode, err)
+		}
+	}
+}
+
+// 检查被包装的 mo 仍收到 getPut(n) 的全部计数
+func checkForwarded(t *testing.T, pool *grpcpool.GRPCPool, mo *grpcpool.DefaultMetricObserver, n int) {
+	t.Helper()
+	metric := mo.Snapshot(false)
+	if metric.DialSuccess != 1 || metric.GetSuccess != int32(n-1) || metric.PutSuccess != int32(n) {
+		t.Errorf("wrapped observer: dial %d, get %d, put %d, want 1, %d, %d", metric.DialSuccess, metric.GetSuccess, metric.PutSuccess, n-1, n)
+	}
+	if stats := pool.Stats().Metric; stats.PutSuccess != int32(n) {
+		t.Errorf("stats PutSuccess = %d, want %d", stats.PutSuccess, n)
+	}
+}
+
+func TestCollector(t *testing.T) {
+	pool, mo := newPool(t)
+	collector := grpcpoolprom.NewCollector("test")
+	if err := collector.Register(pool); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collector)
+
+	getPut(t, pool, 2)
+
+	err := testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"test_grpcpool_used", "test_grpcpool_idle", "test_grpcpool_peak_size",
+		"test_grpcpool_dial_total", "test_grpcpool_get_total", "test_grpcpool_put_total", "test_grpcpool_evict_total")
+	if err != nil {
+		t.Error(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	counts := make(map[string]uint64)
+	for _, family := range families {
+		if family.GetType().String() == "HISTOGRAM" {
+			counts[family.GetName()] = family.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	for name, want := range map[string]uint64{
+		"test_grpcpool_get_wait_seconds":      2,
+		"test_grpcpool_dial_duration_seconds": 1,
+		"test_grpcpool_hold_seconds":          2,
+	} {
+		if got := counts[name]; got != want {
+			t.Errorf("%s sample count = %d, want %d", name, got, want)
+		}
+	}
+	checkForwarded(t, pool, mo, 2)
+	if eo, ok := pool.GetMetricObserver().(grpcpool.EvictObserver); !ok {
+		t.Errorf("observer %T does not implement EvictObserver", pool.GetMetricObserver())
+	} else if eo.IncEvictHealth(); mo.Snapshot(false).EvictHealth != 1 {
+		t.Errorf("wrapped observer EvictHealth = %d, want 1", mo.Snapshot(false).EvictHealth)
+	}
+
+	collector.Unregister(pool)
+	if pool.GetMetricObserver() != grpcpool.MetricObserver(mo) {
+		t.Errorf("observer after Unregister = %T, want the one set before Register", pool.GetMetricObserver())
+	}
+}
+
+// 注册前未为池设置观察者的，Unregister 后恢复为未设置，仍跟随全局的
+func TestCollectorUnregisterUnset(t *testing.T) {
+	global := new(grpcpool.DefaultMetricObserver)
+	grpcpool.RegisterMetricObserver(global)
+	defer grpcpool.RegisterMetricObserver(nil)
+	pool, _ := newPool(t)
+	pool.SetMetricObserver(nil)
+	collector := grpcpoolprom.NewCollector("test")
+	if err := collector.Register(pool); err != nil {
+		t.Errorf("Register: %v", err)
+	}
+	collector.Unregister(pool)
+
+	if old := pool.SwapMetricObserver(nil); old != nil {
+		t.Errorf("pool's own observer after Unregister = %T, want nil", old)
+	}
+	// 之后注册的全局观察者对池生效
+	other := new(grpcpool.DefaultMetricObserver)
+	grpcpool.RegisterMetricObserver(other)
+	if pool.GetMetricObserver() != grpcpool.MetricObserver(other) {
+		t.Errorf("observer after re-registering the global one = %T, want it", pool.GetMetricObserver())
+	}
+}
+
+// 注册后又被包装在外层的观察者，Unregister 不会把它去掉
+func TestCollectorUnregisterWrapped(t *testing.T) {
+	pool, _ := newPool(t)
+	collector := grpcpoolprom.NewCollector("test")
+	if err := collector.Register(pool); err != nil {
+		t.Errorf("Register: %v", err)
+	}
+	outer := struct{ grpcpool.MetricObserver }{pool.GetMetricObserver()}
+	pool.SetMetricObserver(&outer)
+	collector.Unregister(pool)
+
+	if pool.GetMetricObserver() != grpcpool.MetricObserver(&outer) {
+		t.Errorf("observer after Unregister = %T, want the one wrapped after Register", pool.GetMetricObserver())
+	}
+}
+
+// 对使用中的池注册和注销，与 Get 和 Put 并发（用 -race 运行）
+func TestCollectorRegisterWhileServing(t *testing.T) {
+	pool, _ := newPool(t)
+	collector := grpcpoolprom.NewCollector("test")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if err := collector.Register(pool); err != nil {
+				t.Errorf("Register: %v", err)
+			}
+			collector.Unregister(pool)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+			getPut(t, pool, 1)
+		}
+	}
+}
+
+// 取得 collector 导出的还池成功数
+func putSuccess(t *testing.T, collector *grpcpoolprom.Collector) float64 {
+	t.Helper()
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "test_grpcpool_put_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "result" && label.GetValue() == "success" {
+					return metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	t.Fatalf("test_grpcpool_put_total{result=\"success\"} is missing")
+	return 0
+}
+
+// 多个 Collector 并发注册同一个池时，每个都在观察者链中，不会有被并发的安装覆盖而丢失的
+func TestCollectorRegisterConcurrent(t *testing.T) {
+	pool, mo := newPool(t)
+	collectors := make([]*grpcpoolprom.Collector, 8)
+	var wg sync.WaitGroup
+	for i := range collectors {
+		collectors[i] = grpcpoolprom.NewCollector("test")
+		wg.Add(1)
+		go func(collector *grpcpoolprom.Collector) {
+			defer wg.Done()
+			if err := collector.Register(pool); err != nil {
+				t.Errorf("Register: %v", err)
+			}
+		}(collectors[i])
+	}
+	wg.Wait()
+
+	getPut(t, pool, 2)
+	for i, collector := range collectors {
+		if n := putSuccess(t, collector); n != 2 {
+			t.Errorf("collector %d put success = %v, want 2", i, n)
+		}
+	}
+	checkForwarded(t, pool, mo, 2)
+}
+
+// 同一 endpoint 的池不能重复注册到同一个 Collector，注销后可以再注册
+func TestCollectorRegisterDuplicate(t *testing.T) {
+	pool1, _ := newPool(t)
+	pool2, mo2 := newPool(t)
+	collector := grpcpoolprom.NewCollector("test")
+	if err := collector.Register(pool1); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if err := collector.Register(pool2); err == nil {
+		t.Errorf("Register of a second pool for %s succeeded, want an error", pool2.GetEndpoint())
+	}
+	if pool2.GetMetricObserver() != grpcpool.MetricObserver(mo2) {
+		t.Errorf("rejected pool's observer = %T, want it unchanged", pool2.GetMetricObserver())
+	}
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collector)
+	if _, err := registry.Gather(); err != nil {
+		t.Errorf("Gather: %v", err)
+	}
+
+	collector.Unregister(pool1)
+	if err := collector.Register(pool2); err != nil {
+		t.Errorf("Register after Unregister: %v", err)
+	}
+}
+
+// 计数超出 int32 时不回绕为负数（Prometheus 会把变小视为计数器被重置）
+func TestCollectorCounterOverflow(t *testing.T) {
+	pool, _ := newPool(t)
+	collector := grpcpoolprom.NewCollector("test")
+	if err := collector.Register(pool); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	collector.SetPutSuccess(pool, math.MaxInt32)
+	getPut(t, pool, 1)
+	if n := putSuccess(t, collector); n != math.MaxInt32+1 {
+		t.Errorf("put success = %v, want %v", n, uint64(math.MaxInt32+1))
+	}
+}
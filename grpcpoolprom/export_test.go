@@ -0,0 +1,16 @@
+package grpcpoolprom
+
+import (
+	"github.com/eyjian/grpcpool"
+)
+
+// 设置为 pool 导出的还池成功数，用于测试超出 int32 的计数
+func (this *Collector) SetPutSuccess(pool *grpcpool.GRPCPool, n uint64) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	for _, obs := range this.observers {
+		if obs.pool == pool {
+			obs.counters.putSuccess = n
+		}
+	}
+}
@@ -0,0 +1,11 @@
+module github.com/eyjian/grpcpool/grpcpoolprom
+
+go 1.15
+
+require (
+	github.com/eyjian/grpcpool v0.1.0
+	github.com/prometheus/client_golang v1.11.1
+)
+
+// 本地开发时使用上级目录的 grpcpool，对依赖本 module 的其它 module 不起作用
+replace github.com/eyjian/grpcpool => ../
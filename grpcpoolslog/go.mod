@@ -0,0 +1,18 @@
+module github.com/eyjian/grpcpool/grpcpoolslog
+
+go 1.21
+
+require github.com/eyjian/grpcpool v0.1.0
+
+require (
+	github.com/golang/protobuf v1.4.3 // indirect
+	golang.org/x/net v0.0.0-20190311183353-d8887717615a // indirect
+	golang.org/x/sys v0.0.0-20190215142949-d0b11bdaac8a // indirect
+	golang.org/x/text v0.3.0 // indirect
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013 // indirect
+	google.golang.org/grpc v1.33.2 // indirect
+	google.golang.org/protobuf v1.25.0 // indirect
+)
+
+// 本地开发时使用上级目录的 grpcpool，对依赖本 module 的其它 module 不起作用
+replace github.com/eyjian/grpcpool => ../
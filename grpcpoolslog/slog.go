@@ -0,0 +1,25 @@
+// Package grpcpoolslog 将标准库 log/slog 接入 grpcpool 的日志。
+//
+// *slog.Logger 本身即实现了 grpcpool.Logger，可直接传给 RegisterLogger 或 SetLogger，
+// 本包只是为 nil 等情况提供便利。为不提高 grpcpool 本身对 Go 版本的要求，本包是一个独立的 module。
+//
+// 使用方法：
+//
+//	grpcpool.RegisterLogger(grpcpoolslog.New(slog.Default()))
+package grpcpoolslog
+
+import (
+	"log/slog"
+)
+import (
+	"github.com/eyjian/grpcpool"
+)
+
+// 创建 grpcpool.Logger，l 为 nil 时使用 slog.Default()，
+// 输出的日志均带有 component=grpcpool 属性。
+func New(l *slog.Logger) grpcpool.Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return l.With("component", "grpcpool")
+}
@@ -0,0 +1,59 @@
+package grpcpoolslog_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+import (
+	"github.com/eyjian/grpcpool/grpcpoolslog"
+	"github.com/eyjian/grpcpool/grpcpooltest"
+)
+
+// 被拒绝的拨号记 Warn（带 error），成功的拨号记 Debug
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	server := grpcpooltest.NewServer()
+	defer server.Close()
+	pool := server.NewPool(0, 1, 1)
+	defer pool.Close()
+	pool.SetLogger(grpcpoolslog.New(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	server.SetRefuseDials(true)
+	if _, _, err := pool.Get(context.Background()); err == nil {
+		t.Fatal("Get succeeded while dials are refused")
+	}
+	server.SetRefuseDials(false)
+	conn, errcode, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("Get: errcode %d, %v", errcode, err)
+	}
+	pool.Put(conn)
+
+	records := make(map[string]map[string]interface{})
+	decoder := json.NewDecoder(&buf)
+	for decoder.More() {
+		var record map[string]interface{}
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if record["component"] != "grpcpool" || record["endpoint"] != grpcpooltest.ENDPOINT {
+			t.Errorf("%v: component %v, endpoint %v", record["msg"], record["component"], record["endpoint"])
+		}
+		records[record["msg"].(string)] = record
+	}
+	for msg, level := range map[string]string{
+		"grpcpool dial failed": "WARN",
+		"grpcpool dialed":      "DEBUG",
+	} {
+		if record, ok := records[msg]; !ok {
+			t.Errorf("no %q log", msg)
+		} else if record["level"] != level {
+			t.Errorf("%q: level %v, want %s", msg, record["level"], level)
+		}
+	}
+	if record := records["grpcpool dial failed"]; record != nil && record["error"] == nil {
+		t.Errorf("%q has no error", "grpcpool dial failed")
+	}
+}
@@ -0,0 +1,115 @@
+package grpcpooltest
+
+import (
+	"sync"
+	"time"
+)
+import (
+	"github.com/eyjian/grpcpool"
+)
+
+// 假时钟，实现了 grpcpool.Clock 和 grpcpool.TimerClock，只在调用 Advance 时前进，用于确定地触发空闲超时和 releaseIdleCoroutine：
+//
+//	clock := grpcpooltest.NewFakeClock(time.Now())
+//	pool.SetClock(clock)
+//	clock.Advance(11 * time.Second)
+type FakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter // 调用 After 或 NewTimer 后尚未到期（且未被 Stop）的
+}
+
+type fakeWaiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+// NewTimer 返回的定时器
+type fakeTimer struct {
+	clock  *FakeClock
+	waiter *fakeWaiter
+}
+
+// 创建假时钟，起始时间为 start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (this *FakeClock) Now() time.Time {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.now
+}
+
+// 返回的 channel 在时钟前进到 d 之后时收到当时的时间，
+// 不再等待时它仍计入 GetWaiters 直到到期，需要放弃等待的应使用 NewTimer
+func (this *FakeClock) After(d time.Duration) <-chan time.Time {
+	return this.NewTimer(d).C()
+}
+
+// 创建定时器，到期时间同 After，被 Stop 后不再计入 GetWaiters
+func (this *FakeClock) NewTimer(d time.Duration) grpcpool.Timer {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	waiter := &fakeWaiter{deadline: this.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		waiter.c <- this.now
+	} else {
+		this.waiters = append(this.waiters, waiter)
+	}
+	return &fakeTimer{clock: this, waiter: waiter}
+}
+
+func (this *fakeTimer) C() <-chan time.Time {
+	return this.waiter.c
+}
+
+// 停止定时器，已到期或已停止时返回 false
+func (this *fakeTimer) Stop() bool {
+	this.clock.mutex.Lock()
+	defer this.clock.mutex.Unlock()
+	for i, waiter := range this.clock.waiters {
+		if waiter == this.waiter {
+			this.clock.waiters = append(this.clock.waiters[:i], this.clock.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// 使时钟前进 d，并触发到期的 After
+func (this *FakeClock) Advance(d time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.now = this.now.Add(d)
+	waiters := this.waiters[:0]
+	for _, waiter := range this.waiters {
+		if waiter.deadline.After(this.now) {
+			waiters = append(waiters, waiter)
+		} else {
+			waiter.c <- this.now
+		}
+	}
+	this.waiters = waiters
+}
+
+// 取得尚未到期且未被停止的 After 和 NewTimer 数，可用于等待后台协程进入等待状态
+func (this *FakeClock) GetWaiters() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return len(this.waiters)
+}
+
+// 等待尚未到期且未被停止的 After 和 NewTimer 数达到 n，超过 timeout 仍未达到时返回 false
+func (this *FakeClock) WaitForWaiters(n int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for this.GetWaiters() < n {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+var _ grpcpool.TimerClock = (*FakeClock)(nil)
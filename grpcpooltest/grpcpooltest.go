@@ -0,0 +1,243 @@
+// Package grpcpooltest 提供基于 bufconn 的进程内 gRPC 服务端，
+// 用于不依赖真实 TCP 端口（如 test/grpc_server.go）的确定性单元测试。
+//
+// 服务端默认注册了 gRPC 健康检查服务（grpc.health.v1.Health，状态为 SERVING），
+// 并提供模拟服务端重启、拒绝拨号、慢拨号和连接被重置的手段。
+//
+// 使用方法：
+//
+//	server := grpcpooltest.NewServer()
+//	defer server.Close()
+//	pool := server.NewPool(1, 2, 4)
+//	defer pool.Close()
+//	server.SetRefuseDials(true) // 之后的拨号均被拒绝
+package grpcpooltest
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+import (
+	"github.com/eyjian/grpcpool"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// NewPool 创建的池的端点
+const ENDPOINT = "bufconn"
+
+// bufconn 每个方向的缓冲区大小
+const BUFFER_SIZE = 256 * 1024
+
+// 拒绝拨号时返回的错误，为非临时性错误，带 grpc.FailOnNonTempDialError(true) 时拨号立即失败
+var ErrConnRefused error = &net.OpError{Op: "dial", Net: "bufconn", Err: syscall.ECONNREFUSED}
+
+// 进程内的 gRPC 服务端
+type Server struct {
+	dials     int64 // 拨号次数（包括被拒绝的），原子操作
+	dialDelay int64 // 拨号延迟（单位：纳秒），原子操作
+	refuse    int32 // 为 1 表示拒绝拨号，原子操作
+
+	mutex     sync.Mutex
+	listener  *bufconn.Listener                                     // 停止时为 nil
+	server    *grpc.Server                                          // 停止时为 nil
+	health    *health.Server                                        // 停止时为 nil
+	statuses  map[string]healthpb.HealthCheckResponse_ServingStatus // 健康检查状态，重启后保持
+	conns     map[*conn]struct{}                                    // 客户端一侧未关闭的连接
+	registers []func(*grpc.Server)                                  // 每次启动时调用，注册使用者的服务
+	options   []grpc.ServerOption                                   // 每次启动时传给 grpc.NewServer
+	closed    bool
+}
+
+// 创建并启动服务端，register 用于注册使用者自己的服务，每次（重新）启动时都会被调用
+func NewServer(register ...func(*grpc.Server)) *Server {
+	server := new(Server)
+	server.statuses = map[string]healthpb.HealthCheckResponse_ServingStatus{
+		"": healthpb.HealthCheckResponse_SERVING,
+	}
+	server.conns = make(map[*conn]struct{})
+	server.registers = register
+	server.Start()
+	return server
+}
+
+// 启动服务端，已启动或已关闭时什么也不做
+func (this *Server) Start() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.closed || this.server != nil {
+		return
+	}
+
+	this.listener = bufconn.Listen(BUFFER_SIZE)
+	this.server = grpc.NewServer(this.options...)
+	this.health = health.NewServer()
+	for service, status := range this.statuses {
+		this.health.SetServingStatus(service, status)
+	}
+	healthpb.RegisterHealthServer(this.server, this.health)
+	for _, register := range this.registers {
+		register(this.server)
+	}
+	go this.server.Serve(this.listener)
+}
+
+// 停止服务端：已建立的连接被断开，之后的拨号被拒绝，直到调用 Start
+func (this *Server) Stop() {
+	this.mutex.Lock()
+	server := this.server
+	this.server = nil
+	this.listener = nil
+	this.health = nil
+	this.mutex.Unlock()
+
+	if server != nil {
+		server.Stop()
+	}
+	this.ResetConns()
+}
+
+// 模拟服务端重启，等同于 Stop 后 Start
+func (this *Server) Restart() {
+	this.Stop()
+	this.Start()
+}
+
+// 永久关闭服务端
+func (this *Server) Close() {
+	this.mutex.Lock()
+	this.closed = true
+	this.mutex.Unlock()
+	this.Stop()
+}
+
+// 设置是否拒绝拨号（不影响已建立的连接），拒绝时拨号返回 ErrConnRefused
+func (this *Server) SetRefuseDials(refuse bool) {
+	if refuse {
+		atomic.StoreInt32(&this.refuse, 1)
+	} else {
+		atomic.StoreInt32(&this.refuse, 0)
+	}
+}
+
+// 设置创建 grpc.Server 的选项（如用 grpc.KeepaliveParams 设置 MaxConnectionAge），在下次 Start 或 Restart 时生效
+func (this *Server) SetServerOptions(opts ...grpc.ServerOption) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.options = opts
+}
+
+// 设置拨号延迟，用于模拟慢拨号，延迟期间拨号的 context 结束则拨号失败
+func (this *Server) SetDialDelay(delay time.Duration) {
+	atomic.StoreInt64(&this.dialDelay, int64(delay))
+}
+
+// 设置健康检查服务的状态，service 为空表示整个服务端
+func (this *Server) SetServingStatus(service string, status healthpb.HealthCheckResponse_ServingStatus) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.statuses[service] = status
+	if this.health != nil {
+		this.health.SetServingStatus(service, status)
+	}
+}
+
+// 断开所有已建立的连接（模拟连接被重置），服务端继续运行，gRPC 会自动重连
+func (this *Server) ResetConns() int {
+	this.mutex.Lock()
+	conns := make([]*conn, 0, len(this.conns))
+	for c := range this.conns {
+		conns = append(conns, c)
+	}
+	this.mutex.Unlock()
+
+	for _, c := range conns {
+		c.Close()
+	}
+	return len(conns)
+}
+
+// 取得拨号次数（包括被拒绝的和失败的）
+func (this *Server) GetDialCount() int64 {
+	return atomic.LoadInt64(&this.dials)
+}
+
+// 取得客户端一侧未关闭的连接数
+func (this *Server) GetConnCount() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return len(this.conns)
+}
+
+// 供 grpc.WithContextDialer 使用的拨号函数
+func (this *Server) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	atomic.AddInt64(&this.dials, 1)
+	if delay := time.Duration(atomic.LoadInt64(&this.dialDelay)); delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if atomic.LoadInt32(&this.refuse) == 1 {
+		return nil, ErrConnRefused
+	}
+
+	this.mutex.Lock()
+	listener := this.listener
+	this.mutex.Unlock()
+	if listener == nil {
+		return nil, ErrConnRefused
+	}
+	c, err := listener.Dial()
+	if err != nil {
+		return nil, ErrConnRefused
+	}
+
+	tracked := &conn{Conn: c, server: this}
+	this.mutex.Lock()
+	this.conns[tracked] = struct{}{}
+	this.mutex.Unlock()
+	return tracked, nil
+}
+
+// 取得连接本服务端所需的拨号选项：
+// grpc.WithContextDialer、grpc.WithInsecure、grpc.WithBlock 和 grpc.FailOnNonTempDialError(true)，
+// 后两者使拨号失败能在 Get 中立即体现出来
+func (this *Server) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithContextDialer(this.Dial),
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.FailOnNonTempDialError(true),
+	}
+}
+
+// 创建连接本服务端的池，端点为 ENDPOINT，dialOpts 追加在 DialOptions 之后
+func (this *Server) NewPool(initSize, idleSize, peakSize int32, dialOpts ...grpc.DialOption) *grpcpool.GRPCPool {
+	return grpcpool.NewGRPCPool(ENDPOINT, initSize, idleSize, peakSize, append(this.DialOptions(), dialOpts...)...)
+}
+
+// 客户端一侧的连接，关闭时从 Server 中移除
+type conn struct {
+	net.Conn
+	server *Server
+	once   sync.Once
+}
+
+func (this *conn) Close() error {
+	this.once.Do(func() {
+		this.server.mutex.Lock()
+		delete(this.server.conns, this)
+		this.server.mutex.Unlock()
+	})
+	return this.Conn.Close()
+}
@@ -0,0 +1,45 @@
+// Package grpcpoolzap 将 zap 接入 grpcpool 的日志。
+//
+// 为避免 grpcpool 本身依赖 zap，本包是一个独立的 module。
+//
+// 使用方法：
+//
+//	grpcpool.RegisterLogger(grpcpoolzap.New(zapLogger))
+package grpcpoolzap
+
+import (
+	"github.com/eyjian/grpcpool"
+	"go.uber.org/zap"
+)
+
+// 基于 zap.SugaredLogger 的 grpcpool.Logger，keyvals 按 zap 的 Debugw 等的约定处理
+type Logger struct {
+	sugar *zap.SugaredLogger
+}
+
+// 创建 grpcpool.Logger，l 为 nil 时使用 zap.L()，
+// 输出的日志均带有 component=grpcpool 字段。
+func New(l *zap.Logger) *Logger {
+	if l == nil {
+		l = zap.L()
+	}
+	return &Logger{sugar: l.WithOptions(zap.AddCallerSkip(2)).Sugar().With("component", "grpcpool")}
+}
+
+func (this *Logger) Debug(msg string, keyvals ...interface{}) {
+	this.sugar.Debugw(msg, keyvals...)
+}
+
+func (this *Logger) Info(msg string, keyvals ...interface{}) {
+	this.sugar.Infow(msg, keyvals...)
+}
+
+func (this *Logger) Warn(msg string, keyvals ...interface{}) {
+	this.sugar.Warnw(msg, keyvals...)
+}
+
+func (this *Logger) Error(msg string, keyvals ...interface{}) {
+	this.sugar.Errorw(msg, keyvals...)
+}
+
+var _ grpcpool.Logger = (*Logger)(nil)
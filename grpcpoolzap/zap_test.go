@@ -0,0 +1,45 @@
+package grpcpoolzap_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+import (
+	"github.com/eyjian/grpcpool/grpcpooltest"
+	"github.com/eyjian/grpcpool/grpcpoolzap"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// 被拒绝的拨号记 Warn，带池的字段，调用者为池中记日志的函数
+func TestNew(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	server := grpcpooltest.NewServer()
+	defer server.Close()
+	pool := server.NewPool(0, 1, 1)
+	defer pool.Close()
+	pool.SetLogger(grpcpoolzap.New(zap.New(core, zap.AddCaller())))
+	server.SetRefuseDials(true)
+	if _, _, err := pool.Get(context.Background()); err == nil {
+		t.Fatal("Get succeeded while dials are refused")
+	}
+
+	entries := logs.FilterMessage("grpcpool dial failed").All()
+	if len(entries) == 0 {
+		t.Fatal("no dial failed log")
+	}
+	entry := entries[0]
+	if entry.Level != zapcore.WarnLevel {
+		t.Errorf("level %s, want %s", entry.Level, zapcore.WarnLevel)
+	}
+	fields := entry.ContextMap()
+	if fields["component"] != "grpcpool" || fields["endpoint"] != grpcpooltest.ENDPOINT || fields["error"] == nil {
+		t.Errorf("fields = %v, want component, endpoint and error", fields)
+	}
+	// 跳过适配器和池的日志函数
+	if file := filepath.Base(entry.Caller.File); file != "grpcpool.go" {
+		t.Errorf("caller %s, want grpcpool.go", entry.Caller.String())
+	}
+}
@@ -0,0 +1,138 @@
+// 空闲连接的主动健康检查
+//
+// 池中的空闲连接可能已半死（如服务端重启后 NAT 表项失效），
+// 通常要等到 RPC 失败才会发现。开启健康检查后，后台协程周期性地
+// 对空闲连接调用标准的 grpc.health.v1.Health/Check，剔除检查失败的连接，并补充新连接以维持 initSize 个连接。
+// 检查周期按池的 Clock 计时。
+
+package grpcpool
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+import (
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// 连接池的健康状态
+type HealthStatus struct {
+	Service   string // 检查的服务名（空表示服务端整体）
+	Status    string // 最近一次检查的结果，如 SERVING、NOT_SERVING、UNKNOWN 等，尚未检查时为空
+	CheckTime int64  // 最近一次检查的时间（Unix 时间戳，单位：秒）
+	Checked   int32  // 累计检查的连接数
+	Failed    int32  // 累计检查失败的连接数（检查期间被取走的不剔除，留待下次检查）
+	Evicted   int32  // 累计因检查失败被剔除的连接数
+	LastError string // 最近一次检查失败的原因
+}
+
+// 健康检查器
+type healthChecker struct {
+	service  string
+	interval time.Duration
+	timeout  time.Duration
+	mutex    sync.Mutex
+	status   HealthStatus
+}
+
+// 开启空闲连接的健康检查，只能调用一次，重复调用无效。
+// service 为 Health/Check 请求中的服务名，空表示检查服务端整体；
+// interval 为检查周期（小于 1 秒时取 1 秒），timeout 为单次检查的超时时长（不大于 0 时取 1 秒）。
+// 服务端未注册健康检查服务（返回 Unimplemented）时，连接视为健康。
+func (this *GRPCPool) EnableHealthCheck(service string, interval, timeout time.Duration) {
+	if interval < time.Second {
+		interval = time.Second
+	}
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	checker := &healthChecker{
+		service:  service,
+		interval: interval,
+		timeout:  timeout,
+	}
+	checker.status.Service = service
+	this.healthOnce.Do(func() {
+		if this.startCoroutine(func() { this.healthCheckCoroutine(checker) }) {
+			this.healthChecker.Store(checker)
+		}
+	})
+}
+
+// 取得健康状态，未开启健康检查时返回 false
+func (this *GRPCPool) GetHealthStatus() (HealthStatus, bool) {
+	checker, _ := this.healthChecker.Load().(*healthChecker)
+	if checker == nil {
+		return HealthStatus{}, false
+	}
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	return checker.status, true
+}
+
+func (this *GRPCPool) healthCheckCoroutine(checker *healthChecker) {
+	defer this.wg.Done()
+
+	for {
+		timer := this.newTimer(checker.interval)
+		select {
+		case <-this.done:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		// 检查时连接仍留在池中（gRPC 连接可并发使用），不健康的如果仍空闲则剔除，已被取走的留待下次检查
+		evicted := 0
+		for _, conn := range this.idleConns.snapshot() {
+			if !checker.check(conn, this.now()) && this.removeIdle(conn) {
+				this.evict(conn, STATE_IDLE, EVICT_HEALTH)
+				checker.mutex.Lock()
+				checker.status.Evicted++
+				checker.mutex.Unlock()
+				evicted++
+			}
+		}
+		if evicted > 0 {
+			this.replenish()
+		}
+	}
+}
+
+// 对单个连接做健康检查，now 为池的时钟的当前时间，返回 true 表示健康
+func (this *healthChecker) check(conn *GRPCConn, now time.Time) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), this.timeout)
+	defer cancel()
+
+	var statusName string
+	var errInfo string
+	res, err := healthpb.NewHealthClient(conn.GetClient()).Check(ctx, &healthpb.HealthCheckRequest{Service: this.service})
+	if err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			statusName = healthpb.HealthCheckResponse_SERVING.String()
+		} else {
+			statusName = healthpb.HealthCheckResponse_UNKNOWN.String()
+			errInfo = err.Error()
+		}
+	} else {
+		statusName = res.GetStatus().String()
+		if res.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+			errInfo = "health status is " + statusName
+		}
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.status.Status = statusName
+	this.status.CheckTime = now.Unix()
+	this.status.Checked++
+	if errInfo != "" {
+		this.status.Failed++
+		this.status.LastError = errInfo
+		return false
+	}
+	return true
+}
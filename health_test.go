@@ -0,0 +1,81 @@
+package grpcpool_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+import (
+	"github.com/eyjian/grpcpool"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// 按池的时钟周期性地检查空闲连接，剔除不健康的并补充新连接
+func TestHealthCheck(t *testing.T) {
+	server, pool, mo, clock := newTestPool(t, 1, 2, 4)
+	conn := mustGet(t, pool)
+	pool.Put(conn)
+	pool.EnableHealthCheck("", time.Second, time.Second)
+	healthStatus := func() grpcpool.HealthStatus {
+		status, _ := pool.GetHealthStatus()
+		return status
+	}
+
+	// 健康的不剔除
+	if !clock.WaitForWaiters(1, time.Second) {
+		t.Fatal("healthCheckCoroutine is not waiting on the clock")
+	}
+	clock.Advance(time.Second)
+	if !waitFor(func() bool { return healthStatus().Checked == 1 }) {
+		t.Fatalf("checked = %d, want 1", healthStatus().Checked)
+	}
+	if status := healthStatus(); status.Status != "SERVING" || status.Failed != 0 || conn.IsClosed() {
+		t.Errorf("status %s, failed %d, closed %v, want SERVING, 0, false", status.Status, status.Failed, conn.IsClosed())
+	}
+
+	// 不健康的被剔除，并补充新连接
+	server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	if !clock.WaitForWaiters(1, time.Second) {
+		t.Fatal("healthCheckCoroutine is not waiting on the clock")
+	}
+	clock.Advance(time.Second)
+	if !waitFor(func() bool { return healthStatus().Evicted == 1 && pool.GetIdle() == 1 }) {
+		t.Fatalf("evicted %d, idle %d, want 1, 1", healthStatus().Evicted, pool.GetIdle())
+	}
+	if status := healthStatus(); status.Status != "NOT_SERVING" || status.Failed != 1 || status.LastError == "" {
+		t.Errorf("status %s, failed %d, last error %q", status.Status, status.Failed, status.LastError)
+	}
+	if !conn.IsClosed() {
+		t.Error("unhealthy conn is not closed")
+	}
+	if metric := mo.Snapshot(false); metric.EvictHealth != 1 || metric.PutClose != 0 || metric.PutOld != 0 {
+		t.Errorf("EvictHealth %d, PutClose %d, PutOld %d, want 1, 0, 0", metric.EvictHealth, metric.PutClose, metric.PutOld)
+	}
+	checkCounts(t, pool, mo, 0, 1)
+}
+
+// 开启健康检查和连接状态监视与 Close 并发：Close 返回后不应再有后台协程被启动（在 -race 下运行）
+func TestEnableRacingClose(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		_, pool, _, _ := newTestPool(t, 1, 2, 4)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			pool.EnableHealthCheck("", time.Second, time.Second)
+		}()
+		go func() {
+			defer wg.Done()
+			pool.EnableStateWatcher()
+		}()
+		pool.Close()
+		wg.Wait()
+	}
+
+	_, pool, _, _ := newTestPool(t, 1, 2, 4)
+	pool.Close()
+	pool.EnableHealthCheck("", time.Second, time.Second)
+	if _, ok := pool.GetHealthStatus(); ok {
+		t.Errorf("health check enabled after Close")
+	}
+}
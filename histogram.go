@@ -0,0 +1,153 @@
+// 无锁直方图，用于记录 Get 等待、拨号、持有连接等耗时的分布
+
+package grpcpool
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// 直方图的桶数，
+// 第 0 个桶记录小于 1 微秒的，第 i 个桶记录 [2^(i-1), 2^i) 微秒的，最后一个桶记录其余所有的
+const HISTOGRAM_BUCKETS = 32
+
+// 无锁直方图，零值可直接使用，所有操作均为原子操作。
+// 注意：64 位原子操作要求 8 字节对齐，在 32 位平台上应将其放在结构体的最前面。
+type Histogram struct {
+	count   int64                    // 样本数
+	sum     int64                    // 样本总和（单位：纳秒）
+	max     int64                    // 最大样本（单位：纳秒）
+	buckets [HISTOGRAM_BUCKETS]int64 // 各桶的样本数
+}
+
+// 直方图的快照
+type HistogramSnapshot struct {
+	Count   int64
+	Sum     time.Duration
+	Max     time.Duration
+	Buckets [HISTOGRAM_BUCKETS]int64
+}
+
+// 第 i 个桶的上界（不包含）
+func HistogramBucketBound(i int) time.Duration {
+	if i >= HISTOGRAM_BUCKETS-1 {
+		return time.Duration(1<<63 - 1)
+	}
+	return time.Microsecond << uint(i)
+}
+
+func histogramBucketIndex(d time.Duration) int {
+	if d < time.Microsecond {
+		return 0
+	}
+	i := bits.Len64(uint64(d / time.Microsecond))
+	if i >= HISTOGRAM_BUCKETS {
+		i = HISTOGRAM_BUCKETS - 1
+	}
+	return i
+}
+
+// 记录一个样本
+func (this *Histogram) Observe(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	atomic.AddInt64(&this.buckets[histogramBucketIndex(d)], 1)
+	atomic.AddInt64(&this.sum, int64(d))
+	atomic.AddInt64(&this.count, 1)
+	for {
+		max := atomic.LoadInt64(&this.max)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&this.max, max, int64(d)) {
+			break
+		}
+	}
+}
+
+// 取得快照，reset 为 true 时同时清 0（返回清 0 前的值），
+// 快照期间仍在记录的样本可能部分计入本次、部分计入下次，但不会丢失
+func (this *Histogram) Snapshot(reset bool) HistogramSnapshot {
+	var snapshot HistogramSnapshot
+	if reset {
+		snapshot.Count = atomic.SwapInt64(&this.count, 0)
+		snapshot.Sum = time.Duration(atomic.SwapInt64(&this.sum, 0))
+		snapshot.Max = time.Duration(atomic.SwapInt64(&this.max, 0))
+		for i := range this.buckets {
+			snapshot.Buckets[i] = atomic.SwapInt64(&this.buckets[i], 0)
+		}
+	} else {
+		snapshot.Count = atomic.LoadInt64(&this.count)
+		snapshot.Sum = time.Duration(atomic.LoadInt64(&this.sum))
+		snapshot.Max = time.Duration(atomic.LoadInt64(&this.max))
+		for i := range this.buckets {
+			snapshot.Buckets[i] = atomic.LoadInt64(&this.buckets[i])
+		}
+	}
+	return snapshot
+}
+
+// 平均值，无样本时返回 0
+func (this HistogramSnapshot) Mean() time.Duration {
+	if this.Count <= 0 {
+		return 0
+	}
+	return this.Sum / time.Duration(this.Count)
+}
+
+// 百分位数，q 取值范围 [0, 1]，如 0.99 表示 P99，无样本时返回 0，
+// 结果在所在桶内按线性插值估算，不超过最大样本
+func (this HistogramSnapshot) Percentile(q float64) time.Duration {
+	var total int64
+	for _, n := range this.Buckets {
+		total += n
+	}
+	if total <= 0 {
+		return 0
+	}
+	if q < 0 {
+		q = 0
+	} else if q > 1 {
+		q = 1
+	}
+
+	rank := q * float64(total)
+	var cumulative int64
+	for i, n := range this.Buckets {
+		if n == 0 || float64(cumulative+n) < rank {
+			cumulative += n
+			continue
+		}
+		var lower time.Duration
+		if i > 0 {
+			lower = HistogramBucketBound(i - 1)
+		}
+		upper := HistogramBucketBound(i)
+		if this.Max > 0 && upper > this.Max {
+			upper = this.Max
+		}
+		if upper < lower {
+			return upper
+		}
+		return lower + time.Duration(float64(upper-lower)*(rank-float64(cumulative))/float64(n))
+	}
+	return this.Max
+}
+
+// 两个快照之差（this - prev），用于计算两次快照之间的分布，Max 取 this 的
+func (this HistogramSnapshot) Sub(prev HistogramSnapshot) HistogramSnapshot {
+	snapshot := this
+	snapshot.Count -= prev.Count
+	snapshot.Sum -= prev.Sum
+	for i := range snapshot.Buckets {
+		snapshot.Buckets[i] -= prev.Buckets[i]
+	}
+	return snapshot
+}
+
+// 用快照的值覆盖，仅用于尚未被并发访问的直方图（如快照所在的 Metric）
+func (this *Histogram) restore(snapshot HistogramSnapshot) {
+	this.count = snapshot.Count
+	this.sum = int64(snapshot.Sum)
+	this.max = int64(snapshot.Max)
+	this.buckets = snapshot.Buckets
+}
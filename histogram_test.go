@@ -0,0 +1,200 @@
+package grpcpool_test
+
+import (
+	"testing"
+	"time"
+)
+import (
+	"github.com/eyjian/grpcpool"
+)
+
+func TestHistogramBucketBound(t *testing.T) {
+	for _, tc := range []struct {
+		i    int
+		want time.Duration
+	}{
+		{0, time.Microsecond},
+		{1, 2 * time.Microsecond},
+		{10, 1024 * time.Microsecond},
+		{grpcpool.HISTOGRAM_BUCKETS - 2, time.Microsecond << uint(grpcpool.HISTOGRAM_BUCKETS-2)},
+		{grpcpool.HISTOGRAM_BUCKETS - 1, time.Duration(1<<63 - 1)},
+	} {
+		if got := grpcpool.HistogramBucketBound(tc.i); got != tc.want {
+			t.Errorf("HistogramBucketBound(%d) = %s, want %s", tc.i, got, tc.want)
+		}
+	}
+}
+
+// 样本落入 [HistogramBucketBound(i-1), HistogramBucketBound(i)) 所在的桶
+func TestHistogramObserve(t *testing.T) {
+	for _, tc := range []struct {
+		d      time.Duration
+		bucket int
+	}{
+		{-time.Second, 0}, // 负值按 0 记录
+		{0, 0},
+		{time.Microsecond - 1, 0},
+		{time.Microsecond, 1},
+		{2*time.Microsecond - 1, 1},
+		{2 * time.Microsecond, 2},
+		{3 * time.Microsecond, 2},
+		{4 * time.Microsecond, 3},
+		{time.Millisecond, 10},
+		{time.Second, 20},
+		{time.Hour, grpcpool.HISTOGRAM_BUCKETS - 1},
+		{time.Duration(1<<63 - 1), grpcpool.HISTOGRAM_BUCKETS - 1},
+	} {
+		var h grpcpool.Histogram
+		h.Observe(tc.d)
+		snapshot := h.Snapshot(false)
+		for i, n := range snapshot.Buckets {
+			want := int64(0)
+			if i == tc.bucket {
+				want = 1
+			}
+			if n != want {
+				t.Errorf("Observe(%s): bucket %d = %d, want %d", tc.d, i, n, want)
+			}
+		}
+		if snapshot.Count != 1 {
+			t.Errorf("Observe(%s): count = %d, want 1", tc.d, snapshot.Count)
+		}
+	}
+}
+
+// 无样本时平均值和百分位数都为 0
+func TestHistogramEmpty(t *testing.T) {
+	var h grpcpool.Histogram
+	snapshot := h.Snapshot(false)
+	if mean := snapshot.Mean(); mean != 0 {
+		t.Errorf("Mean = %s, want 0", mean)
+	}
+	for _, q := range []float64{-1, 0, 0.5, 0.99, 1, 2} {
+		if got := snapshot.Percentile(q); got != 0 {
+			t.Errorf("Percentile(%v) = %s, want 0", q, got)
+		}
+	}
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	// 50 个 500ns（第 0 个桶）和 50 个 10us（第 4 个桶 [8us, 16us)，上界取 Max 即 10us）
+	var mixed grpcpool.Histogram
+	for i := 0; i < 50; i++ {
+		mixed.Observe(500 * time.Nanosecond)
+		mixed.Observe(10 * time.Microsecond)
+	}
+	// 只有一个 3us（第 2 个桶 [2us, 4us)，上界取 Max 即 3us）
+	var single grpcpool.Histogram
+	single.Observe(3 * time.Microsecond)
+	// Sub 得到的快照 Max 可能小于桶的下界，此时取 Max
+	stale := grpcpool.HistogramSnapshot{Count: 1, Max: 5 * time.Microsecond}
+	stale.Buckets[4] = 1
+
+	for _, tc := range []struct {
+		name     string
+		snapshot grpcpool.HistogramSnapshot
+		q        float64
+		want     time.Duration
+	}{
+		{"single p0", single.Snapshot(false), 0, 2 * time.Microsecond},
+		{"single p50", single.Snapshot(false), 0.5, 2500 * time.Nanosecond},
+		{"single p100", single.Snapshot(false), 1, 3 * time.Microsecond},
+		{"single q below 0", single.Snapshot(false), -1, 2 * time.Microsecond},
+		{"single q above 1", single.Snapshot(false), 2, 3 * time.Microsecond},
+		{"mixed p25", mixed.Snapshot(false), 0.25, 500 * time.Nanosecond},
+		{"mixed p50", mixed.Snapshot(false), 0.5, time.Microsecond},
+		{"mixed p75", mixed.Snapshot(false), 0.75, 9 * time.Microsecond},
+		{"mixed p100", mixed.Snapshot(false), 1, 10 * time.Microsecond},
+		{"max below bucket", stale, 0.5, 5 * time.Microsecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.snapshot.Percentile(tc.q); got != tc.want {
+				t.Errorf("Percentile(%v) = %s, want %s", tc.q, got, tc.want)
+			}
+		})
+	}
+	if mean := mixed.Snapshot(false).Mean(); mean != 5250*time.Nanosecond {
+		t.Errorf("Mean = %s, want 5.25us", mean)
+	}
+}
+
+func TestHistogramSnapshotReset(t *testing.T) {
+	var h grpcpool.Histogram
+	h.Observe(time.Millisecond)
+	h.Observe(3 * time.Millisecond)
+
+	snapshot := h.Snapshot(true)
+	if snapshot.Count != 2 || snapshot.Sum != 4*time.Millisecond || snapshot.Max != 3*time.Millisecond {
+		t.Errorf("snapshot: count %d, sum %s, max %s, want 2, 4ms, 3ms", snapshot.Count, snapshot.Sum, snapshot.Max)
+	}
+	if empty := h.Snapshot(false); empty != (grpcpool.HistogramSnapshot{}) {
+		t.Errorf("snapshot after reset = %+v, want empty", empty)
+	}
+}
+
+func TestHistogramSub(t *testing.T) {
+	var h grpcpool.Histogram
+	h.Observe(5 * time.Millisecond)
+	prev := h.Snapshot(false)
+	h.Observe(time.Millisecond)
+	h.Observe(time.Millisecond)
+	delta := h.Snapshot(false).Sub(prev)
+
+	if delta.Count != 2 || delta.Sum != 2*time.Millisecond {
+		t.Errorf("delta: count %d, sum %s, want 2, 2ms", delta.Count, delta.Sum)
+	}
+	// Max 不能相减，取 this 的
+	if delta.Max != 5*time.Millisecond {
+		t.Errorf("delta max = %s, want 5ms", delta.Max)
+	}
+	if n := delta.Buckets[10]; n != 2 {
+		t.Errorf("delta bucket 10 = %d, want 2", n)
+	}
+	if n := delta.Buckets[13]; n != 0 {
+		t.Errorf("delta bucket 13 = %d, want 0", n)
+	}
+	if got := (grpcpool.HistogramSnapshot{}).Sub(grpcpool.HistogramSnapshot{}); got.Percentile(0.5) != 0 || got.Mean() != 0 {
+		t.Errorf("empty delta: p50 %s, mean %s, want 0, 0", got.Percentile(0.5), got.Mean())
+	}
+}
+
+// 快照和增量中的直方图由 restore 还原，应与原直方图的快照一致
+func TestHistogramRestore(t *testing.T) {
+	mo := new(grpcpool.DefaultMetricObserver)
+	mo.ObserveGetWait(time.Millisecond)
+	mo.ObserveHold(time.Second)
+	prev := mo.Snapshot(false)
+	mo.ObserveGetWait(3 * time.Millisecond)
+	mo.ObserveClose(time.Microsecond)
+
+	metric := mo.Snapshot(true)
+	for _, tc := range []struct {
+		name      string
+		histogram *grpcpool.Histogram
+		count     int64
+		sum, max  time.Duration
+	}{
+		{"GetWait", &metric.GetWait, 2, 4 * time.Millisecond, 3 * time.Millisecond},
+		{"DialDuration", &metric.DialDuration, 0, 0, 0},
+		{"HoldTime", &metric.HoldTime, 1, time.Second, time.Second},
+		{"CloseDuration", &metric.CloseDuration, 1, time.Microsecond, time.Microsecond},
+	} {
+		snapshot := tc.histogram.Snapshot(false)
+		if snapshot.Count != tc.count || snapshot.Sum != tc.sum || snapshot.Max != tc.max {
+			t.Errorf("%s: count %d, sum %s, max %s, want %d, %s, %s", tc.name, snapshot.Count, snapshot.Sum, snapshot.Max, tc.count, tc.sum, tc.max)
+		}
+	}
+
+	delta := metric.Delta(&prev)
+	getWait := delta.GetWait.Snapshot(false)
+	if getWait.Count != 1 || getWait.Sum != 3*time.Millisecond || getWait.Buckets[12] != 1 {
+		t.Errorf("delta GetWait: count %d, sum %s, bucket 12 %d, want 1, 3ms, 1", getWait.Count, getWait.Sum, getWait.Buckets[12])
+	}
+	if hold := delta.HoldTime.Snapshot(false); hold.Count != 0 || hold.Percentile(0.99) != 0 {
+		t.Errorf("delta HoldTime: count %d, p99 %s, want 0, 0", hold.Count, hold.Percentile(0.99))
+	}
+	after := mo.Snapshot(false)
+	if n := after.GetWait.Snapshot(false).Count; n != 0 {
+		t.Errorf("GetWait count after reset = %d, want 0", n)
+	}
+}
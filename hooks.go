@@ -0,0 +1,120 @@
+// 连接生命周期事件
+//
+// MetricObserver 只能计数，看不到连接本身和原因，
+// 调用池的成员函数 SetEventHook 设置 EventHook 后，可在拨号、取走、归还、剔除连接和关闭池时收到事件，
+// 用于审计、跟踪和按连接记账等。
+
+package grpcpool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 连接被剔除（关闭并移出池）的原因
+type EvictReason int
+
+const (
+	EVICT_IDLE_TIMEOUT EvictReason = 1  // 空闲超时（空闲连接数超过 initSize 时）
+	EVICT_PEAK_TIMEOUT EvictReason = 2  // 高峰超时（空闲连接数超过 idleSize 时）
+	EVICT_FULL         EvictReason = 3  // 归还时池已满
+	EVICT_CLOSED       EvictReason = 4  // 归还时连接已被关闭（如调用出错后由使用者关闭）
+	EVICT_POOL_CLOSED  EvictReason = 5  // 池被关闭
+	EVICT_LIFETIME     EvictReason = 6  // 存活时长超过 maxLifetime（见 SetMaxLifetime）
+	EVICT_HEALTH       EvictReason = 7  // 健康检查失败（见 EnableHealthCheck）
+	EVICT_BROKEN       EvictReason = 8  // 进入过 TransientFailure 或 Shutdown 状态（见 EnableStateWatcher）
+	EVICT_DISCARDED    EvictReason = 9  // 被使用者丢弃（见 Lease 的 Discard）
+	EVICT_DISCONNECTED EvictReason = 10 // 从 Ready 转为 Idle 或 Connecting，即连接断开后重连，通常是服务端发送了 GOAWAY（见 EnableStateWatcher）
+)
+
+func (this EvictReason) String() string {
+	switch this {
+	case EVICT_IDLE_TIMEOUT:
+		return "idle_timeout"
+	case EVICT_PEAK_TIMEOUT:
+		return "peak_timeout"
+	case EVICT_FULL:
+		return "full"
+	case EVICT_CLOSED:
+		return "closed"
+	case EVICT_POOL_CLOSED:
+		return "pool_closed"
+	case EVICT_LIFETIME:
+		return "lifetime"
+	case EVICT_HEALTH:
+		return "health"
+	case EVICT_BROKEN:
+		return "broken"
+	case EVICT_DISCARDED:
+		return "discarded"
+	case EVICT_DISCONNECTED:
+		return "disconnected"
+	default:
+		return "unknown"
+	}
+}
+
+// 连接生命周期事件的接收者，
+// 各函数在池的调用路径上同步调用，应尽快返回，不能调用池的 Get、Put 和 Close。
+// 只关心部分事件时，可嵌入 NopEventHook。
+type EventHook interface {
+	// 拨号结束，latency 为拨号耗时，失败时 conn 为 nil、err 为 gRPC 的原始错误
+	OnDial(conn *GRPCConn, latency time.Duration, err error)
+	// 连接被 Get 取走，wait 为 Get 的耗时，dialed 为 true 表示连接是新拨号创建的
+	OnBorrow(conn *GRPCConn, wait time.Duration, dialed bool)
+	// 连接被 Put 归还（在放回池或被剔除之前），hold 为连接在 Get 和 Put 之间的持有时长
+	OnReturn(conn *GRPCConn, hold time.Duration)
+	// 连接被剔除，调用时连接已被关闭（可能在 closeCoroutine 中调用）
+	OnEvict(conn *GRPCConn, reason EvictReason)
+	// 池被关闭，调用时空闲连接均已被剔除，后台协程均已退出
+	OnClose(pool *GRPCPool)
+}
+
+// 剔除观察者，MetricObserver 的实现如果同时实现了本接口，则还会收到不经 Put 计数的剔除数
+// （健康检查失败、broken 和 disconnected，包括在池中被剔除的和归还时被剔除的）
+type EvictObserver interface {
+	IncEvictHealth() int32       // 因健康检查失败被剔除的连接数增一
+	IncEvictBroken() int32       // 因进入过 TransientFailure 或 Shutdown 状态被剔除的连接数增一
+	IncEvictDisconnected() int32 // 因连接断开（从 Ready 转为 Idle 或 Connecting，通常是 GOAWAY）被剔除的连接数增一
+}
+
+// 不做任何事的 EventHook，供嵌入
+type NopEventHook struct{}
+
+func (NopEventHook) OnDial(conn *GRPCConn, latency time.Duration, err error)  {}
+func (NopEventHook) OnBorrow(conn *GRPCConn, wait time.Duration, dialed bool) {}
+func (NopEventHook) OnReturn(conn *GRPCConn, hold time.Duration)              {}
+func (NopEventHook) OnEvict(conn *GRPCConn, reason EvictReason)               {}
+func (NopEventHook) OnClose(pool *GRPCPool)                                   {}
+
+// 设置事件接收者，传 nil 表示不接收，应在连接池投入使用前调用
+func (this *GRPCPool) SetEventHook(hook EventHook) {
+	this.eventHook = hook
+}
+
+func (this *GRPCPool) GetEventHook() EventHook {
+	return this.eventHook
+}
+
+// 剔除 from 状态的连接：转为 EVICTING，将连接标记为已关闭，不再记录它，再交给 closeCoroutine 关闭（见 closer.go），
+// 关闭后转为 CLOSED，并记日志和通知 EventHook。
+// 连接已不是 from 状态时（已被其它协程转换）什么也不做
+func (this *GRPCPool) evict(conn *GRPCConn, from ConnState, reason EvictReason) {
+	if !this.setState(conn, from, STATE_EVICTING) {
+		return
+	}
+	if eo, ok := this.GetMetricObserver().(EvictObserver); ok {
+		switch reason {
+		case EVICT_HEALTH:
+			eo.IncEvictHealth()
+		case EVICT_BROKEN:
+			eo.IncEvictBroken()
+		case EVICT_DISCONNECTED:
+			eo.IncEvictDisconnected()
+		}
+	}
+	// 已被使用者关闭的无需再关闭
+	retired := atomic.CompareAndSwapInt32(&conn.closed, 0, 1)
+	this.forgetConn(conn)
+	this.closeEvicted(conn, reason, retired)
+}
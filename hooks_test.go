@@ -0,0 +1,142 @@
+package grpcpool_test
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+import (
+	"github.com/eyjian/grpcpool"
+)
+
+// 一次 EventHook 调用
+type event struct {
+	name    string
+	conn    *grpcpool.GRPCConn
+	elapsed time.Duration // OnDial 的 latency、OnBorrow 的 wait 或 OnReturn 的 hold
+	dialed  bool
+	err     error
+	reason  grpcpool.EvictReason
+	pool    *grpcpool.GRPCPool
+}
+
+// 把所有事件按顺序发到 channel 的 EventHook
+type eventRecorder struct {
+	events chan event
+}
+
+func (this *eventRecorder) OnDial(conn *grpcpool.GRPCConn, latency time.Duration, err error) {
+	this.events <- event{name: "dial", conn: conn, elapsed: latency, err: err}
+}
+
+func (this *eventRecorder) OnBorrow(conn *grpcpool.GRPCConn, wait time.Duration, dialed bool) {
+	this.events <- event{name: "borrow", conn: conn, elapsed: wait, dialed: dialed}
+}
+
+func (this *eventRecorder) OnReturn(conn *grpcpool.GRPCConn, hold time.Duration) {
+	this.events <- event{name: "return", conn: conn, elapsed: hold}
+}
+
+func (this *eventRecorder) OnEvict(conn *grpcpool.GRPCConn, reason grpcpool.EvictReason) {
+	this.events <- event{name: "evict", conn: conn, reason: reason}
+}
+
+func (this *eventRecorder) OnClose(pool *grpcpool.GRPCPool) {
+	this.events <- event{name: "close", pool: pool}
+}
+
+// 取得下一个事件，检查其名称和连接（conn 为 nil 时不检查连接）
+func (this *eventRecorder) next(t *testing.T, name string, conn *grpcpool.GRPCConn) event {
+	t.Helper()
+	select {
+	case e := <-this.events:
+		if e.name != name {
+			t.Fatalf("event = %s, want %s", e.name, name)
+		}
+		if conn != nil && e.conn != conn {
+			t.Errorf("%s: conn #%d, want #%d", name, e.conn.GetID(), conn.GetID())
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatalf("no %s event", name)
+		return event{}
+	}
+}
+
+// 没有多余的事件
+func (this *eventRecorder) none(t *testing.T) {
+	t.Helper()
+	select {
+	case e := <-this.events:
+		t.Errorf("unexpected %s event", e.name)
+	default:
+	}
+}
+
+func TestEventHook(t *testing.T) {
+	server, pool, _, _ := newTestPool(t, 1, 1, 2)
+	hook := &eventRecorder{events: make(chan event, 16)}
+	pool.SetEventHook(hook)
+	if pool.GetEventHook() != grpcpool.EventHook(hook) {
+		t.Fatalf("GetEventHook = %T", pool.GetEventHook())
+	}
+
+	// 拨号失败：conn 为 nil，err 为 gRPC 的原始错误，不会有 OnBorrow
+	server.SetRefuseDials(true)
+	if _, _, err := pool.Get(context.Background()); err == nil {
+		t.Fatal("Get succeeded while dials are refused")
+	}
+	if e := hook.next(t, "dial", nil); e.conn != nil || e.err == nil || e.elapsed <= 0 {
+		t.Errorf("failed dial: conn %v, err %v, latency %s", e.conn, e.err, e.elapsed)
+	}
+	hook.none(t)
+	server.SetRefuseDials(false)
+
+	// 新拨号的连接：先 OnDial 后 OnBorrow，wait 包含拨号耗时
+	conns := make([]*grpcpool.GRPCConn, 2)
+	for i := range conns {
+		conns[i] = mustGet(t, pool)
+		dial := hook.next(t, "dial", conns[i])
+		if dial.err != nil || dial.elapsed <= 0 {
+			t.Errorf("dial #%d: err %v, latency %s", i, dial.err, dial.elapsed)
+		}
+		if borrow := hook.next(t, "borrow", conns[i]); !borrow.dialed || borrow.elapsed < dial.elapsed {
+			t.Errorf("borrow #%d: dialed %v, wait %s, want dialed and at least %s", i, borrow.dialed, borrow.elapsed, dial.elapsed)
+		}
+	}
+
+	// 归还：hold 为 Get 和 Put 之间的时长；已被使用者关闭的在 OnReturn 后被剔除
+	time.Sleep(10 * time.Millisecond)
+	conns[1].Close()
+	for _, conn := range conns {
+		pool.Put(conn)
+		if e := hook.next(t, "return", conn); e.elapsed < 10*time.Millisecond {
+			t.Errorf("hold = %s, want at least 10ms", e.elapsed)
+		}
+	}
+	if e := hook.next(t, "evict", conns[1]); e.reason != grpcpool.EVICT_CLOSED {
+		t.Errorf("evict reason = %s, want closed", e.reason)
+	}
+
+	// 取自池中的：没有 OnDial，dialed 为 false
+	conn := mustGet(t, pool)
+	if conn != conns[0] {
+		t.Fatalf("Get = conn #%d, want the pooled #%d", conn.GetID(), conns[0].GetID())
+	}
+	if e := hook.next(t, "borrow", conn); e.dialed {
+		t.Error("borrow from the pool: dialed = true")
+	}
+	pool.Put(conn)
+	hook.next(t, "return", conn)
+
+	// 关闭池：先剔除空闲连接，最后 OnClose
+	pool.Close()
+	if e := hook.next(t, "evict", conn); e.reason != grpcpool.EVICT_POOL_CLOSED {
+		t.Errorf("evict reason = %s, want pool_closed", e.reason)
+	}
+	if e := hook.next(t, "close", nil); e.pool != pool {
+		t.Errorf("OnClose pool = %p, want %p", e.pool, pool)
+	}
+	pool.Close()
+	hook.none(t)
+}
@@ -0,0 +1,263 @@
+// 空闲连接的选择策略
+//
+// 空闲连接按归还的先后排列（最久未用的在前），Get 按池的选择策略取：
+// 1) SELECT_FIFO（默认）：取最久未用的，轮流使用所有空闲连接（与原来 chan 队列的行为一致），空闲连接都保持活跃而不易超时；
+// 2) SELECT_LIFO：取最近归还的，负载下降时少用的连接得以超时关闭，使 idleTimeout 和 peakTimeout 生效；
+// 3) SELECT_RANDOM：随机取一个。
+// releaseIdleCoroutine 总是检查最久未用的（见 reaper.go）。
+//
+// 用 NewShardedGRPCPool 创建的池，空闲连接分散在多个分片中，各自有锁，
+// 协程优先使用所在 P 常用的分片，该分片空时从其它分片取（work stealing），以减少多核下的锁竞争，
+// 选择策略只在分片内生效（如 SELECT_FIFO 取本分片中最久未用的）；
+// 连接的计数也按分片分组（见 connCounters），peakSize 等限制仍是全局的。
+
+package grpcpool
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 空闲连接的选择策略
+type SelectPolicy int32
+
+const (
+	SELECT_FIFO   SelectPolicy = 0 // 先进先出，取最久未用的
+	SELECT_LIFO   SelectPolicy = 1 // 后进先出，取最近归还的
+	SELECT_RANDOM SelectPolicy = 2 // 随机取
+)
+
+func (this SelectPolicy) String() string {
+	switch this {
+	case SELECT_FIFO:
+		return "fifo"
+	case SELECT_LIFO:
+		return "lifo"
+	case SELECT_RANDOM:
+		return "random"
+	default:
+		return "unknown"
+	}
+}
+
+// 设置空闲连接的选择策略，可随时调用
+func (this *GRPCPool) SetSelectPolicy(policy SelectPolicy) {
+	atomic.StoreInt32(&this.selectPolicy, int32(policy))
+}
+
+func (this *GRPCPool) GetSelectPolicy() SelectPolicy {
+	return SelectPolicy(atomic.LoadInt32(&this.selectPolicy))
+}
+
+// 空闲连接列表的一个分片
+type idleShard struct {
+	mutex    sync.Mutex
+	conns    []*GRPCConn // 按归还先后排列，最久未用的在前
+	capacity int         // 最多容纳的连接数
+	closed   bool        // 为 true 表示已被关闭，不再接受连接
+	random   *rand.Rand  // 用于 SELECT_RANDOM
+}
+
+func newIdleShard(capacity int) *idleShard {
+	list := new(idleShard)
+	list.conns = make([]*GRPCConn, 0, capacity)
+	list.capacity = capacity
+	list.random = rand.New(rand.NewSource(time.Now().UnixNano()))
+	return list
+}
+
+// 放入连接（当作最近使用的，放在最后面），返回 SUCCESS、POOL_FULL 或 POOL_CLOSED
+func (this *idleShard) push(conn *GRPCConn) uint32 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.closed {
+		return POOL_CLOSED
+	}
+	if len(this.conns) >= this.capacity {
+		return POOL_FULL
+	}
+	this.conns = append(this.conns, conn)
+	return SUCCESS
+}
+
+// 按策略取出一个连接，空时返回 nil，已关闭时 closed 为 true
+func (this *idleShard) pop(policy SelectPolicy) (conn *GRPCConn, closed bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	n := len(this.conns)
+	if n == 0 {
+		return nil, this.closed
+	}
+
+	var i int
+	switch policy {
+	case SELECT_LIFO:
+		i = n - 1
+	case SELECT_RANDOM:
+		i = this.random.Intn(n)
+	default:
+		i = 0
+	}
+	conn = this.conns[i]
+	copy(this.conns[i:], this.conns[i+1:])
+	this.conns[n-1] = nil
+	this.conns = this.conns[:n-1]
+	return conn, false
+}
+
+// 取得最前面（最久未用）的连接（不取出），空时返回 nil
+func (this *idleShard) front() *GRPCConn {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if len(this.conns) == 0 {
+		return nil
+	}
+	return this.conns[0]
+}
+
+// 取出指定的连接，不在列表中（如已被 Get 取走）时返回 false
+func (this *idleShard) remove(conn *GRPCConn) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for i, c := range this.conns {
+		if c == conn {
+			n := len(this.conns)
+			copy(this.conns[i:], this.conns[i+1:])
+			this.conns[n-1] = nil
+			this.conns = this.conns[:n-1]
+			return true
+		}
+	}
+	return false
+}
+
+// 取得当前所有空闲连接（不取出），最久未用的在前
+func (this *idleShard) snapshot() []*GRPCConn {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return append([]*GRPCConn(nil), this.conns...)
+}
+
+// 关闭列表，取出并返回剩余的所有连接
+func (this *idleShard) close() []*GRPCConn {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.closed = true
+	conns := this.conns
+	this.conns = nil
+	return conns
+}
+
+// 空闲连接列表，由一个或多个分片组成
+type idleList struct {
+	shards   []*idleShard
+	counters []connCounters // 连接计数，每个分片一组（见 local）
+	hints    sync.Pool      // *int，当前 P 常用的分片下标（sync.Pool 按 P 缓存，因此同一 P 上的协程多用同一分片）
+	next     uint32         // 分配分片下标用
+}
+
+// 创建空闲连接列表，每个分片都最多容纳 capacity 个连接（总数由池的计数限制）
+func newIdleList(capacity, shards int) *idleList {
+	if shards < 1 {
+		shards = 1
+	}
+	list := new(idleList)
+	list.shards = make([]*idleShard, shards)
+	list.counters = make([]connCounters, shards)
+	for i := range list.shards {
+		list.shards[i] = newIdleShard(capacity)
+	}
+	list.hints.New = func() interface{} {
+		i := int(atomic.AddUint32(&list.next, 1) % uint32(shards))
+		return &i
+	}
+	return list
+}
+
+// 取得当前协程优先使用的分片下标
+func (this *idleList) home() int {
+	if len(this.shards) == 1 {
+		return 0
+	}
+	hint := this.hints.Get().(*int)
+	i := *hint
+	this.hints.Put(hint)
+	return i
+}
+
+// 取得当前协程所在分片的连接计数
+func (this *idleList) local() *connCounters {
+	return &this.counters[this.home()]
+}
+
+// 放入连接，优先放入当前协程的分片，满时放入其它分片，
+// 返回 SUCCESS、POOL_FULL 或 POOL_CLOSED
+func (this *idleList) push(conn *GRPCConn) uint32 {
+	n := len(this.shards)
+	i := this.home()
+	for k := 0; k < n; k++ {
+		if errcode := this.shards[(i+k)%n].push(conn); errcode != POOL_FULL {
+			return errcode
+		}
+	}
+	return POOL_FULL
+}
+
+// 按策略从分片内取出一个连接，优先从当前协程的分片取，空时从其它分片取，
+// 全空时返回 nil，已关闭时 closed 为 true
+func (this *idleList) pop(policy SelectPolicy) (conn *GRPCConn, closed bool) {
+	n := len(this.shards)
+	i := this.home()
+	for k := 0; k < n; k++ {
+		conn, closed := this.shards[(i+k)%n].pop(policy)
+		if conn != nil || closed {
+			return conn, closed
+		}
+	}
+	return nil, false
+}
+
+// 取得所有分片中最久未用的连接（不取出）及其所在分片，全空时返回 nil，供 releaseIdleCoroutine 使用
+func (this *idleList) coldest() (*GRPCConn, *idleShard) {
+	var coldest *GRPCConn
+	var coldestShard *idleShard
+	var coldestUtime int64
+	for _, shard := range this.shards {
+		if conn := shard.front(); conn != nil {
+			if utime := atomic.LoadInt64(&conn.utime); coldest == nil || utime < coldestUtime {
+				coldest, coldestShard, coldestUtime = conn, shard, utime
+			}
+		}
+	}
+	return coldest, coldestShard
+}
+
+// 取出指定的连接，不在列表中（如已被 Get 取走）时返回 false
+func (this *idleList) remove(conn *GRPCConn) bool {
+	for _, shard := range this.shards {
+		if shard.remove(conn) {
+			return true
+		}
+	}
+	return false
+}
+
+// 取得当前所有空闲连接（不取出）
+func (this *idleList) snapshot() []*GRPCConn {
+	var conns []*GRPCConn
+	for _, shard := range this.shards {
+		conns = append(conns, shard.snapshot()...)
+	}
+	return conns
+}
+
+// 关闭列表，取出并返回剩余的所有连接
+func (this *idleList) close() []*GRPCConn {
+	var conns []*GRPCConn
+	for _, shard := range this.shards {
+		conns = append(conns, shard.close()...)
+	}
+	return conns
+}
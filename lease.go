@@ -0,0 +1,121 @@
+// 租约
+//
+// 归还连接有三种途径：Put、PutGeneration 以及 Lease 的 Release 和 Discard，
+// 推荐的是租约：Put 无法识别过期的归还，PutGeneration 需要调用者自行保存取走的序号，
+// 二者为兼容已有的 Get/Put 用法保留。
+//
+// 直接使用 GRPCConn 时，调用出错后要先 conn.Close() 再 pool.Put(conn) 才能使连接不再放回池，
+// 这是隐含的约定。GetLease 返回的 *Lease 把它变成显式的：
+//
+//	lease, errcode, err := pool.GetLease(ctx)
+//	if err != nil {
+//		...
+//	}
+//	err = lease.Client().Invoke(ctx, method, req, res)
+//	if status.Code(err) == codes.Unavailable {
+//		lease.Discard(err) // 关闭连接，不再放回池
+//	} else {
+//		lease.Release() // 放回池
+//	}
+//
+// Release 和 Discard 只有第一次调用生效，之后的调用什么也不做，因此可以 defer lease.Release()。
+// 租约记录了连接被取走的序号，连接已被其它途径归还（如对 Conn() 调用了 Put）并被其它协程取走时，
+// Release 和 Discard 返回 CONN_MISUSED 和 ErrStalePut，而不是把别人正在用的连接放回池或关闭。
+// 租约未被 Release 或 Discard 就被垃圾回收时记警告日志（连接不会被放回池，used 不会减少）。
+
+package grpcpool
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+import (
+	"google.golang.org/grpc"
+)
+
+// 一次 Get 取得的连接的租约，应调用 Release 或 Discard 结束
+type Lease struct {
+	pool     *GRPCPool
+	conn     *GRPCConn
+	gen      int64     // 连接被取走的序号（见 GRPCConn 的 GetGeneration），归还时用于识别过期的租约
+	ltime    time.Time // 取得租约的时间
+	released int32     // 为 1 表示已调用过 Release 或 Discard
+}
+
+// 从连接池取一个连接的租约，错误代码和错误信息同 Get，
+// 是取还连接的推荐途径，用完后调用 Release 或 Discard 结束
+func (this *GRPCPool) GetLease(ctx context.Context) (*Lease, uint32, error) {
+	conn, errcode, err := this.Get(ctx)
+	if conn == nil {
+		return nil, errcode, err
+	}
+	lease := &Lease{pool: this, conn: conn, gen: conn.GetGeneration(), ltime: time.Now()}
+	runtime.SetFinalizer(lease, (*Lease).finalize)
+	return lease, errcode, err
+}
+
+// 取得 gRPC 连接，只应在 Release 或 Discard 之前使用
+func (this *Lease) Client() *grpc.ClientConn {
+	return this.conn.GetClient()
+}
+
+// 取得租约对应的连接，用于取得连接的标识和统计等，不应对它调用池的 Put
+func (this *Lease) Conn() *GRPCConn {
+	return this.conn
+}
+
+// 结束租约，将连接放回池，返回值同 Put，
+// 只有第一次调用（包括 Discard）生效，之后的调用返回 SUCCESS
+func (this *Lease) Release() (uint, error) {
+	if !atomic.CompareAndSwapInt32(&this.released, 0, 1) {
+		return SUCCESS, nil
+	}
+	runtime.SetFinalizer(this, nil)
+	return this.pool.PutGeneration(this.conn, this.gen)
+}
+
+// 结束租约，关闭连接而不放回池（剔除原因为 EVICT_DISCARDED），reason 为丢弃的原因（可为 nil），记入日志，
+// 与其它被剔除的连接一样交给 closeCoroutine 关闭（遵守 SetCloseGracePeriod 设置的宽限期），不阻塞调用者，
+// 只有第一次调用（包括 Release）生效，之后的调用返回 SUCCESS
+func (this *Lease) Discard(reason error) (uint, error) {
+	if !atomic.CompareAndSwapInt32(&this.released, 0, 1) {
+		return SUCCESS, nil
+	}
+	runtime.SetFinalizer(this, nil)
+	if this.stale() {
+		return this.pool.PutGeneration(this.conn, this.gen)
+	}
+	atomic.StoreInt32(&this.conn.discarded, 1)
+	atomic.StoreInt32(&this.conn.failed, 1)
+	if reason != nil {
+		this.pool.logInfo("grpcpool lease discarded", "conn", this.conn.id, "reason", reason.Error())
+	} else {
+		this.pool.logInfo("grpcpool lease discarded", "conn", this.conn.id)
+	}
+	return this.pool.PutGeneration(this.conn, this.gen)
+}
+
+// 连接是否已不属于本租约（已被归还，可能又被其它协程取走）
+func (this *Lease) stale() bool {
+	return this.conn.GetGeneration() != this.gen
+}
+
+// 租约被垃圾回收时调用（只有未结束的才会被调用）
+func (this *Lease) finalize() {
+	if atomic.LoadInt32(&this.released) == 0 {
+		this.pool.logWarn("grpcpool lease garbage collected without being released", "conn", this.conn.id, "leased_for", time.Since(this.ltime))
+	}
+}
+
+// 归还的连接被 Discard 丢弃的为 EVICT_DISCARDED，被使用者关闭的为 EVICT_CLOSED，都不是时 ok 为 false
+func closedReason(conn *GRPCConn) (reason EvictReason, ok bool) {
+	if atomic.LoadInt32(&conn.discarded) == 1 {
+		return EVICT_DISCARDED, true
+	}
+	if conn.IsClosed() {
+		return EVICT_CLOSED, true
+	}
+	return 0, false
+}
@@ -0,0 +1,191 @@
+package grpcpool_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+import (
+	"github.com/eyjian/grpcpool"
+	"google.golang.org/grpc/connectivity"
+)
+
+// 记录事件的 EventHook
+type recordHook struct {
+	grpcpool.NopEventHook
+	evicts chan grpcpool.EvictReason
+}
+
+func (this *recordHook) OnEvict(conn *grpcpool.GRPCConn, reason grpcpool.EvictReason) {
+	this.evicts <- reason
+}
+
+// 把警告日志发到 channel 的 Logger，其它日志丢弃
+type warnLogger struct {
+	warns chan string
+}
+
+func (this *warnLogger) Debug(msg string, keyvals ...interface{}) {}
+func (this *warnLogger) Info(msg string, keyvals ...interface{})  {}
+func (this *warnLogger) Error(msg string, keyvals ...interface{}) {}
+
+func (this *warnLogger) Warn(msg string, keyvals ...interface{}) {
+	select {
+	case this.warns <- msg:
+	default:
+	}
+}
+
+func TestLeaseRelease(t *testing.T) {
+	_, pool, mo, _ := newTestPool(t, 1, 2, 4)
+
+	lease, errcode, err := pool.GetLease(context.Background())
+	if err != nil {
+		t.Fatalf("GetLease = %d, %v", errcode, err)
+	}
+	if lease.Client() == nil || lease.Conn().GetPoolState() != grpcpool.STATE_LEASED {
+		t.Fatalf("lease conn state = %s", lease.Conn().GetPoolState())
+	}
+	checkCounts(t, pool, mo, 1, 0)
+
+	// 只有第一次生效
+	for i := 0; i < 3; i++ {
+		if errcode, err := lease.Release(); errcode != grpcpool.SUCCESS {
+			t.Errorf("Release #%d = %d, %v", i, errcode, err)
+		}
+	}
+	lease.Discard(nil)
+	checkCounts(t, pool, mo, 0, 1)
+	if n := mo.Snapshot(false).PutMisuse; n != 0 {
+		t.Errorf("PutMisuse = %d, want 0", n)
+	}
+}
+
+// 被丢弃的连接与其它被剔除的连接一样由 closeCoroutine 关闭，宽限期内 ClientConn 仍未关闭
+func TestLeaseDiscard(t *testing.T) {
+	_, pool, mo, clock := newTestPool(t, 1, 2, 4)
+	hook := &recordHook{evicts: make(chan grpcpool.EvictReason, 1)}
+	pool.SetEventHook(hook)
+	pool.SetCloseGracePeriod(5 * time.Second)
+
+	lease, _, err := pool.GetLease(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errcode, _ := lease.Discard(errors.New("unavailable")); errcode != grpcpool.CONN_CLOSED {
+		t.Errorf("Discard = %d, want CONN_CLOSED", errcode)
+	}
+	if errcode, _ := lease.Release(); errcode != grpcpool.SUCCESS {
+		t.Errorf("Release after Discard = %d, want SUCCESS", errcode)
+	}
+	conn := lease.Conn()
+	if !conn.IsClosed() || conn.GetPoolState() != grpcpool.STATE_EVICTING {
+		t.Errorf("discarded conn: closed %v, state %s", conn.IsClosed(), conn.GetPoolState())
+	}
+	if !clock.WaitForWaiters(1, time.Second) {
+		t.Fatal("closeCoroutine is not waiting for the grace period")
+	}
+	if state := conn.GetClient().GetState(); state == connectivity.Shutdown {
+		t.Errorf("ClientConn closed within the grace period")
+	}
+
+	clock.Advance(5 * time.Second)
+	if reason := <-hook.evicts; reason != grpcpool.EVICT_DISCARDED {
+		t.Errorf("evict reason = %s, want discarded", reason)
+	}
+	checkCounts(t, pool, mo, 0, 0)
+}
+
+func TestLeaseFinalizer(t *testing.T) {
+	_, pool, _, _ := newTestPool(t, 1, 2, 4)
+	logger := &warnLogger{warns: make(chan string, 4)}
+	pool.SetLogger(logger)
+
+	func() {
+		if _, _, err := pool.GetLease(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		runtime.GC()
+		select {
+		case msg := <-logger.warns:
+			if msg != "grpcpool lease garbage collected without being released" {
+				t.Errorf("warning = %q", msg)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	t.Error("no warning for leaked lease")
+}
+
+// 连接被其它途径归还并被再次取走后，过期的 PutGeneration 和租约的 Release、Discard 被拒绝，不影响新的持有者
+func TestPutStale(t *testing.T) {
+	if grpcpool.PANIC_ON_MISUSE {
+		t.Skip("misuse panics in grpcpooldebug mode")
+	}
+	_, pool, mo, _ := newTestPool(t, 1, 2, 4)
+
+	conn := mustGet(t, pool)
+	generation := conn.GetGeneration()
+	if generation == 0 || generation != conn.GetBorrowCount() {
+		t.Fatalf("generation = %d, borrow count %d", generation, conn.GetBorrowCount())
+	}
+	pool.Put(conn)
+	if n := conn.GetGeneration(); n != 0 {
+		t.Errorf("generation in the pool = %d, want 0", n)
+	}
+	again := mustGet(t, pool)
+	if again != conn {
+		t.Fatalf("Get returned a new conn, want the idle one")
+	}
+	errcode, err := pool.PutGeneration(conn, generation)
+	if errcode != grpcpool.CONN_MISUSED || !errors.Is(err, grpcpool.ErrStalePut) {
+		t.Errorf("stale PutGeneration = %d, %v, want CONN_MISUSED and ErrStalePut", errcode, err)
+	}
+	checkCounts(t, pool, mo, 1, 0)
+	if errcode, err := pool.PutGeneration(again, again.GetGeneration()); errcode != grpcpool.SUCCESS {
+		t.Fatalf("PutGeneration = %d, %v", errcode, err)
+	}
+	if errcode, err := pool.PutGeneration(again, generation+1); errcode != grpcpool.CONN_MISUSED || !errors.Is(err, grpcpool.ErrDoublePut) {
+		t.Errorf("second PutGeneration = %d, %v, want CONN_MISUSED and ErrDoublePut", errcode, err)
+	}
+
+	for _, end := range []struct {
+		name string
+		end  func(lease *grpcpool.Lease) (uint, error)
+	}{
+		{"Release", (*grpcpool.Lease).Release},
+		{"Discard", func(lease *grpcpool.Lease) (uint, error) { return lease.Discard(nil) }},
+	} {
+		lease, _, err := pool.GetLease(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool.Put(lease.Conn())
+		other, _, err := pool.GetLease(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other.Conn() != lease.Conn() {
+			t.Fatalf("%s: GetLease returned a new conn, want the idle one", end.name)
+		}
+		if errcode, err := end.end(lease); errcode != grpcpool.CONN_MISUSED || !errors.Is(err, grpcpool.ErrStalePut) {
+			t.Errorf("stale %s = %d, %v, want CONN_MISUSED and ErrStalePut", end.name, errcode, err)
+		}
+		if state := other.Conn().GetPoolState(); state != grpcpool.STATE_LEASED || other.Conn().IsClosed() {
+			t.Errorf("%s: new holder's conn state = %s, closed %v", end.name, state, other.Conn().IsClosed())
+		}
+		if errcode, err := other.Release(); errcode != grpcpool.SUCCESS {
+			t.Errorf("%s: Release by the new holder = %d, %v", end.name, errcode, err)
+		}
+	}
+	checkCounts(t, pool, mo, 0, 1)
+	if n := mo.Snapshot(false).PutMisuse; n != 4 {
+		t.Errorf("PutMisuse = %d, want 4", n)
+	}
+}
@@ -0,0 +1,239 @@
+// 自适应并发限制器
+//
+// 静态的 peakSize 无法跟随服务端的实际处理能力变化，
+// Limiter 根据经由连接池的 RPC 耗时和出错情况动态调整允许的并发数，
+// 在服务端被压垮之前拒绝多出的请求（Get 返回 POOL_LIMITED）。
+//
+// 作为样本的耗时只取 RPC 本身：Invoke 自动测量，自行调用 RPC 的应在调用后用 GRPCConn 的 ReportRTT 报告，
+// Put 时反馈给 Release。Get 到 Put 的持有时长可能包含与 RPC 无关的工作，不作为耗时，
+// 既未报告耗时又未出错的归还只调用 Cancel 归还许可。
+//
+// 提供两种实现：
+// 1) AIMDLimiter：加性增、乘性减（Additive Increase Multiplicative Decrease）；
+// 2) GradientLimiter：基于长短期耗时比值（梯度）调整，类似 TCP Vegas。
+
+package grpcpool
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 并发限制器
+type Limiter interface {
+	Acquire() bool                          // 申请一个许可，返回 false 表示已超出当前并发限制
+	Release(rtt time.Duration, failed bool) // 归还许可，并反馈本次调用的耗时和是否出错
+	Cancel()                                // 归还许可，但不作为样本（如池空等与服务端无关的失败）
+	GetLimit() int32                        // 当前允许的并发数
+	GetInflight() int32                     // 当前正在进行中的调用数
+}
+
+// 限流观察者，MetricObserver 的实现如果同时实现了本接口，则还会收到被限流的计数
+type LimitObserver interface {
+	IncGetLimited() int32 // 取池被限流数增一
+}
+
+// 在 inflight 未达 limit 时将其增一
+func acquireInflight(inflight *int32, limit *int32) bool {
+	for {
+		n := atomic.LoadInt32(inflight)
+		if n >= atomic.LoadInt32(limit) {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(inflight, n, n+1) {
+			return true
+		}
+	}
+}
+
+func clampLimit(limit float64, minLimit, maxLimit int32) float64 {
+	if limit < float64(minLimit) {
+		return float64(minLimit)
+	}
+	if limit > float64(maxLimit) {
+		return float64(maxLimit)
+	}
+	return limit
+}
+
+// AIMD 限制器：
+// 调用成功且并发已用到限制的一半以上时，限制加一；
+// 调用出错或耗时超过 timeout 时，限制乘以 backoffRatio。
+type AIMDLimiter struct {
+	minLimit     int32
+	maxLimit     int32
+	limit        int32         // 当前限制（供 Acquire 无锁读取）
+	inflight     int32         // 进行中的调用数
+	mutex        sync.Mutex    // 保护以下成员
+	backoffRatio float64       // 乘性减的系数（默认值 0.9，可调用成员函数 SetBackoffRatio 修改）
+	timeout      time.Duration // 耗时超过该值视为过载（默认值 0 表示不按耗时判断，可调用成员函数 SetTimeout 修改）
+	value        float64       // 当前限制的精确值
+}
+
+// 创建 AIMD 限制器，
+// initLimit 为初始限制，限制总是在 [minLimit, maxLimit] 范围内调整。
+func NewAIMDLimiter(initLimit, minLimit, maxLimit int32) *AIMDLimiter {
+	limiter := new(AIMDLimiter)
+	if minLimit < 1 {
+		minLimit = 1
+	}
+	if maxLimit < minLimit {
+		maxLimit = minLimit
+	}
+	limiter.minLimit = minLimit
+	limiter.maxLimit = maxLimit
+	limiter.backoffRatio = 0.9
+	limiter.value = clampLimit(float64(initLimit), minLimit, maxLimit)
+	limiter.limit = int32(limiter.value)
+	return limiter
+}
+
+// 可在使用中调用，与 Release 并发安全
+func (this *AIMDLimiter) SetBackoffRatio(ratio float64) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if ratio <= 0 || ratio >= 1 {
+		this.backoffRatio = 0.9
+	} else {
+		this.backoffRatio = ratio
+	}
+}
+
+// 可在使用中调用，与 Release 并发安全
+func (this *AIMDLimiter) SetTimeout(timeout time.Duration) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.timeout = timeout
+}
+
+func (this *AIMDLimiter) Acquire() bool {
+	return acquireInflight(&this.inflight, &this.limit)
+}
+
+func (this *AIMDLimiter) Release(rtt time.Duration, failed bool) {
+	inflight := atomic.AddInt32(&this.inflight, -1) + 1 // 本次调用期间的并发数
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if failed || (this.timeout > 0 && rtt > this.timeout) {
+		this.value = clampLimit(this.value*this.backoffRatio, this.minLimit, this.maxLimit)
+	} else if float64(inflight)*2 >= this.value {
+		this.value = clampLimit(this.value+1, this.minLimit, this.maxLimit)
+	}
+	atomic.StoreInt32(&this.limit, int32(this.value))
+}
+
+func (this *AIMDLimiter) Cancel() {
+	atomic.AddInt32(&this.inflight, -1)
+}
+
+func (this *AIMDLimiter) GetLimit() int32 {
+	return atomic.LoadInt32(&this.limit)
+}
+
+func (this *AIMDLimiter) GetInflight() int32 {
+	return atomic.LoadInt32(&this.inflight)
+}
+
+// 梯度限制器：
+// 以耗时的长期均值作为无负载时的基准，短期均值作为当前耗时，
+// gradient = 长期均值 / 短期均值（取值范围 [0.5, 1]），
+// 新限制 = 当前限制 * gradient + sqrt(当前限制)（允许的排队数），
+// 再按 smoothing 平滑。耗时升高时限制随之下降，耗时回落时限制逐步回升。
+// 调用出错时，限制乘以 0.9。
+type GradientLimiter struct {
+	minLimit  int32
+	maxLimit  int32
+	limit     int32      // 当前限制（供 Acquire 无锁读取）
+	inflight  int32      // 进行中的调用数
+	mutex     sync.Mutex // 保护以下成员
+	smoothing float64    // 平滑系数（默认值 0.2，可调用成员函数 SetSmoothing 修改）
+	value     float64    // 当前限制的精确值
+	longRtt   float64    // 耗时的长期指数移动平均（单位：纳秒）
+	shortRtt  float64    // 耗时的短期指数移动平均（单位：纳秒）
+}
+
+// 创建梯度限制器，
+// initLimit 为初始限制，限制总是在 [minLimit, maxLimit] 范围内调整。
+func NewGradientLimiter(initLimit, minLimit, maxLimit int32) *GradientLimiter {
+	limiter := new(GradientLimiter)
+	if minLimit < 1 {
+		minLimit = 1
+	}
+	if maxLimit < minLimit {
+		maxLimit = minLimit
+	}
+	limiter.minLimit = minLimit
+	limiter.maxLimit = maxLimit
+	limiter.smoothing = 0.2
+	limiter.value = clampLimit(float64(initLimit), minLimit, maxLimit)
+	limiter.limit = int32(limiter.value)
+	return limiter
+}
+
+// 可在使用中调用，与 Release 并发安全
+func (this *GradientLimiter) SetSmoothing(smoothing float64) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if smoothing <= 0 || smoothing > 1 {
+		this.smoothing = 0.2
+	} else {
+		this.smoothing = smoothing
+	}
+}
+
+func (this *GradientLimiter) Acquire() bool {
+	return acquireInflight(&this.inflight, &this.limit)
+}
+
+func (this *GradientLimiter) Release(rtt time.Duration, failed bool) {
+	inflight := atomic.AddInt32(&this.inflight, -1) + 1 // 本次调用期间的并发数
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if failed {
+		this.value = clampLimit(this.value*0.9, this.minLimit, this.maxLimit)
+		atomic.StoreInt32(&this.limit, int32(this.value))
+		return
+	}
+	if rtt <= 0 {
+		return
+	}
+
+	sample := float64(rtt)
+	if this.longRtt == 0 {
+		this.longRtt = sample
+		this.shortRtt = sample
+	} else {
+		this.longRtt = this.longRtt*0.99 + sample*0.01
+		this.shortRtt = this.shortRtt*0.9 + sample*0.1
+	}
+	// 并发远未用满时，耗时不能反映负载，不扩大限制
+	if float64(inflight)*2 < this.value {
+		return
+	}
+	// 耗时大幅回落后，让长期基准尽快跟着下降
+	if this.longRtt/this.shortRtt > 2 {
+		this.longRtt *= 0.95
+	}
+
+	gradient := math.Max(0.5, math.Min(1.0, this.longRtt/this.shortRtt))
+	newLimit := this.value*gradient + math.Sqrt(this.value)
+	newLimit = this.value*(1-this.smoothing) + newLimit*this.smoothing
+	this.value = clampLimit(newLimit, this.minLimit, this.maxLimit)
+	atomic.StoreInt32(&this.limit, int32(this.value))
+}
+
+func (this *GradientLimiter) Cancel() {
+	atomic.AddInt32(&this.inflight, -1)
+}
+
+func (this *GradientLimiter) GetLimit() int32 {
+	return atomic.LoadInt32(&this.limit)
+}
+
+func (this *GradientLimiter) GetInflight() int32 {
+	return atomic.LoadInt32(&this.inflight)
+}
@@ -0,0 +1,370 @@
+package grpcpool_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+import (
+	"github.com/eyjian/grpcpool"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// 一次调用：期间的并发数为 inflight（为 0 时取当前限制，即并发用满），重复 repeat 次（为 0 时 1 次）
+type limiterStep struct {
+	inflight int32
+	rtt      time.Duration
+	failed   bool
+	repeat   int
+}
+
+// 模拟 step 所述的调用：先申请 inflight 个许可，以第一个反馈样本，其余的取消
+func runLimiterSteps(t *testing.T, limiter grpcpool.Limiter, steps []limiterStep) {
+	t.Helper()
+	for _, step := range steps {
+		repeat := step.repeat
+		if repeat == 0 {
+			repeat = 1
+		}
+		for i := 0; i < repeat; i++ {
+			inflight := step.inflight
+			if inflight == 0 {
+				inflight = limiter.GetLimit()
+			}
+			for k := int32(0); k < inflight; k++ {
+				if !limiter.Acquire() {
+					t.Fatalf("Acquire %d of %d failed, limit %d", k+1, inflight, limiter.GetLimit())
+				}
+			}
+			limiter.Release(step.rtt, step.failed)
+			for k := int32(1); k < inflight; k++ {
+				limiter.Cancel()
+			}
+			if n := limiter.GetInflight(); n != 0 {
+				t.Fatalf("inflight = %d after the call, want 0", n)
+			}
+		}
+	}
+}
+
+// 初始限制被限定在 [minLimit, maxLimit] 内，minLimit 至少为 1
+func TestLimiterBounds(t *testing.T) {
+	for _, tc := range []struct {
+		name                          string
+		initLimit, minLimit, maxLimit int32
+		want                          int32
+	}{
+		{"within", 10, 1, 100, 10},
+		{"below min", 0, 5, 100, 5},
+		{"above max", 200, 1, 100, 100},
+		{"min at least 1", 0, 0, 10, 1},
+		{"max below min", 5, 8, 4, 8},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := grpcpool.NewAIMDLimiter(tc.initLimit, tc.minLimit, tc.maxLimit).GetLimit(); got != tc.want {
+				t.Errorf("AIMD limit = %d, want %d", got, tc.want)
+			}
+			if got := grpcpool.NewGradientLimiter(tc.initLimit, tc.minLimit, tc.maxLimit).GetLimit(); got != tc.want {
+				t.Errorf("gradient limit = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+// 超出限制的 Acquire 被拒绝，Release 和 Cancel 后可再申请
+func TestLimiterAcquire(t *testing.T) {
+	for _, limiter := range []grpcpool.Limiter{
+		grpcpool.NewAIMDLimiter(2, 1, 10),
+		grpcpool.NewGradientLimiter(2, 1, 10),
+	} {
+		if !limiter.Acquire() || !limiter.Acquire() {
+			t.Fatalf("%T: Acquire within the limit failed", limiter)
+		}
+		if limiter.Acquire() {
+			t.Errorf("%T: Acquire beyond the limit succeeded", limiter)
+		}
+		limiter.Cancel()
+		if !limiter.Acquire() {
+			t.Errorf("%T: Acquire after Cancel failed", limiter)
+		}
+		limiter.Cancel()
+		limiter.Cancel()
+		if n := limiter.GetInflight(); n != 0 {
+			t.Errorf("%T: inflight = %d, want 0", limiter, n)
+		}
+	}
+}
+
+func TestAIMDLimiter(t *testing.T) {
+	for _, tc := range []struct {
+		name                          string
+		initLimit, minLimit, maxLimit int32
+		ratio                         float64       // 为 0 时不设置
+		timeout                       time.Duration // 为 0 时不设置
+		steps                         []limiterStep
+		want                          int32
+	}{
+		{"increase when busy", 10, 1, 100, 0, 0, []limiterStep{{inflight: 5}}, 11},
+		{"hold when not busy", 10, 1, 100, 0, 0, []limiterStep{{inflight: 4}}, 10},
+		{"additive increase", 10, 1, 100, 0, 0, []limiterStep{{repeat: 5}}, 15},
+		{"capped at max", 10, 1, 12, 0, 0, []limiterStep{{repeat: 5}}, 12},
+		{"backoff on error", 10, 1, 100, 0, 0, []limiterStep{{inflight: 1, failed: true}}, 9},
+		{"backoff on timeout", 10, 1, 100, 0, 100 * time.Millisecond, []limiterStep{{inflight: 5, rtt: 200 * time.Millisecond}}, 9},
+		{"within timeout", 10, 1, 100, 0, 100 * time.Millisecond, []limiterStep{{inflight: 5, rtt: 50 * time.Millisecond}}, 11},
+		{"custom ratio", 10, 1, 100, 0.5, 0, []limiterStep{{inflight: 1, failed: true}}, 5},
+		{"invalid ratio", 10, 1, 100, 1.5, 0, []limiterStep{{inflight: 1, failed: true}}, 9},
+		{"floored at min", 10, 3, 100, 0, 0, []limiterStep{{inflight: 1, failed: true, repeat: 20}}, 3},
+		// 9 -> 8.1 -> 7.29，再加一为 8.29
+		{"fractional backoff", 10, 1, 100, 0, 0, []limiterStep{{inflight: 1, failed: true, repeat: 3}, {}}, 8},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter := grpcpool.NewAIMDLimiter(tc.initLimit, tc.minLimit, tc.maxLimit)
+			if tc.ratio != 0 {
+				limiter.SetBackoffRatio(tc.ratio)
+			}
+			if tc.timeout != 0 {
+				limiter.SetTimeout(tc.timeout)
+			}
+			runLimiterSteps(t, limiter, tc.steps)
+			if got := limiter.GetLimit(); got != tc.want {
+				t.Errorf("limit = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGradientLimiter(t *testing.T) {
+	steady := limiterStep{rtt: time.Millisecond, repeat: 100}
+	for _, tc := range []struct {
+		name                          string
+		initLimit, minLimit, maxLimit int32
+		steps                         []limiterStep
+		min, max                      int32 // 结束时限制的范围
+	}{
+		{"backoff on error", 10, 1, 100, []limiterStep{{inflight: 1, failed: true}}, 9, 9},
+		{"floored at min", 10, 4, 100, []limiterStep{{inflight: 1, failed: true, repeat: 20}}, 4, 4},
+		{"zero rtt ignored", 10, 1, 100, []limiterStep{{rtt: 0, repeat: 10}}, 10, 10},
+		{"hold when not busy", 10, 1, 100, []limiterStep{{inflight: 1, rtt: time.Millisecond, repeat: 100}}, 10, 10},
+		// 耗时不变时 gradient 为 1，每次增加 sqrt(limit) 的一部分
+		{"grow on steady latency", 10, 1, 1000, []limiterStep{steady}, 100, 1000},
+		{"capped at max", 10, 1, 50, []limiterStep{steady}, 50, 50},
+		// 耗时升至 10 倍后 gradient 降到 0.5，限制收缩到 sqrt(limit) 量级
+		{"shrink on latency spike", 10, 1, 50, []limiterStep{steady, {rtt: 10 * time.Millisecond, repeat: 100}}, 1, 10},
+		// 耗时回落后逐步回升
+		{"recover after spike", 10, 1, 50, []limiterStep{steady, {rtt: 10 * time.Millisecond, repeat: 100}, steady}, 20, 50},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			limiter := grpcpool.NewGradientLimiter(tc.initLimit, tc.minLimit, tc.maxLimit)
+			runLimiterSteps(t, limiter, tc.steps)
+			if got := limiter.GetLimit(); got < tc.min || got > tc.max {
+				t.Errorf("limit = %d, want [%d, %d]", got, tc.min, tc.max)
+			}
+		})
+	}
+}
+
+// 设置参数与 Release 并发（在 -race 下检查数据竞争）
+func TestLimiterSetConcurrent(t *testing.T) {
+	aimd := grpcpool.NewAIMDLimiter(10, 1, 100)
+	gradient := grpcpool.NewGradientLimiter(10, 1, 100)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			aimd.SetBackoffRatio(0.5)
+			aimd.SetTimeout(time.Millisecond)
+			gradient.SetSmoothing(0.5)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			for _, limiter := range []grpcpool.Limiter{aimd, gradient} {
+				if limiter.Acquire() {
+					limiter.Release(time.Millisecond, i%10 == 0)
+				}
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+// 记录 Release 反馈的 Limiter，限制固定
+type recordingLimiter struct {
+	mutex    sync.Mutex
+	limit    int32
+	inflight int32
+	failed   []bool          // 每次 Release 的 failed
+	rtts     []time.Duration // 每次 Release 的 rtt
+	cancels  int
+}
+
+func (this *recordingLimiter) Acquire() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.inflight >= this.limit {
+		return false
+	}
+	this.inflight++
+	return true
+}
+
+func (this *recordingLimiter) Release(rtt time.Duration, failed bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.inflight--
+	this.failed = append(this.failed, failed)
+	this.rtts = append(this.rtts, rtt)
+}
+
+func (this *recordingLimiter) Cancel() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.inflight--
+	this.cancels++
+}
+
+func (this *recordingLimiter) GetLimit() int32 {
+	return this.limit
+}
+
+func (this *recordingLimiter) GetInflight() int32 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.inflight
+}
+
+// 取得并清空已记录的 Release
+func (this *recordingLimiter) takeReleases() []bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	failed := this.failed
+	this.failed = nil
+	this.rtts = nil
+	return failed
+}
+
+// 取得并清空已记录的 Release 的 rtt
+func (this *recordingLimiter) takeRTTs() []time.Duration {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	rtts := this.rtts
+	this.failed = nil
+	this.rtts = nil
+	return rtts
+}
+
+func (this *recordingLimiter) getCancels() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.cancels
+}
+
+// 达到限制时 Get 返回 POOL_LIMITED，Put 归还许可
+func TestPoolLimited(t *testing.T) {
+	_, pool, mo, _ := newTestPool(t, 1, 2, 4)
+	limiter := &recordingLimiter{limit: 1}
+	pool.SetLimiter(limiter)
+
+	conn := mustGet(t, pool)
+	if conn, errcode, err := pool.Get(context.Background()); conn != nil || errcode != grpcpool.POOL_LIMITED || err == nil {
+		t.Fatalf("Get at the limit: errcode %d, %v, want POOL_LIMITED", errcode, err)
+	}
+	if n := mo.Snapshot(false).GetLimited; n != 1 {
+		t.Errorf("get limited = %d, want 1", n)
+	}
+	checkCounts(t, pool, mo, 1, 0)
+
+	// 未报告 RPC 耗时，持有时长不作为样本
+	time.Sleep(10 * time.Millisecond)
+	pool.Put(conn)
+	if got := limiter.takeReleases(); len(got) != 0 {
+		t.Errorf("releases after Put = %v, want none", got)
+	}
+	if n := limiter.getCancels(); n != 1 {
+		t.Errorf("cancels = %d, want 1", n)
+	}
+	if n := limiter.GetInflight(); n != 0 {
+		t.Errorf("inflight = %d, want 0", n)
+	}
+
+	// 报告的 RPC 耗时作为样本
+	conn = mustGet(t, pool)
+	conn.ReportRTT(3 * time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	pool.Put(conn)
+	if got := limiter.takeRTTs(); len(got) != 1 || got[0] != 3*time.Millisecond {
+		t.Errorf("release rtts = %v, want [3ms]", got)
+	}
+
+	// 上次报告的耗时不带到下一次使用
+	pool.Put(mustGet(t, pool))
+	if got := limiter.takeReleases(); len(got) != 0 {
+		t.Errorf("releases = %v, want none", got)
+	}
+
+	// 连接在使用中被关闭，视为出错
+	conn = mustGet(t, pool)
+	conn.Close()
+	pool.Put(conn)
+	if got := limiter.takeReleases(); len(got) != 1 || !got[0] {
+		t.Errorf("releases = %v, want [true]", got)
+	}
+}
+
+// Invoke 按调用结果反馈：Unavailable、DeadlineExceeded 和 ResourceExhausted 视为出错，其它错误不算
+func TestInvokeLimiter(t *testing.T) {
+	server, pool, mo, _ := newTestPool(t, 0, 2, 4)
+	// 未注册的方法以方法名最后一段作为错误代码返回
+	server.SetServerOptions(grpc.UnknownServiceHandler(func(srv interface{}, stream grpc.ServerStream) error {
+		method, _ := grpc.MethodFromServerStream(stream)
+		for c := codes.OK; c <= codes.Unauthenticated; c++ {
+			if method == "/test.Error/"+c.String() {
+				return status.Error(c, "injected")
+			}
+		}
+		return status.Error(codes.Unimplemented, method)
+	}))
+	server.Restart()
+	limiter := &recordingLimiter{limit: 4}
+	pool.SetLimiter(limiter)
+
+	for _, tc := range []struct {
+		method  string
+		errcode uint32
+		failed  bool
+		idle    int32 // 调用后的空闲连接数
+	}{
+		{"/grpc.health.v1.Health/Check", grpcpool.SUCCESS, false, 1},
+		{"/test.Error/" + codes.InvalidArgument.String(), grpcpool.GRPC_ERROR, false, 1},
+		{"/test.Error/" + codes.DeadlineExceeded.String(), grpcpool.GRPC_ERROR, true, 1},
+		{"/test.Error/" + codes.ResourceExhausted.String(), grpcpool.GRPC_ERROR, true, 1},
+		// 连接被关闭，不再放回池
+		{"/test.Error/" + codes.Unavailable.String(), grpcpool.GRPC_ERROR, true, 0},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			var res healthpb.HealthCheckResponse
+			if errcode, _ := pool.Invoke(context.Background(), tc.method, &healthpb.HealthCheckRequest{}, &res); errcode != tc.errcode {
+				t.Errorf("Invoke: errcode %d, want %d", errcode, tc.errcode)
+			}
+			limiter.mutex.Lock()
+			failed, rtts := limiter.failed, limiter.rtts
+			limiter.mutex.Unlock()
+			if len(failed) != 1 || failed[0] != tc.failed {
+				t.Errorf("releases = %v, want [%v]", failed, tc.failed)
+			} else if rtts[0] <= 0 {
+				t.Errorf("release rtt = %v, want the Invoke duration", rtts[0])
+			}
+			limiter.takeReleases()
+			checkCounts(t, pool, mo, 0, tc.idle)
+		})
+	}
+	if n := limiter.GetInflight(); n != 0 {
+		t.Errorf("inflight = %d, want 0", n)
+	}
+}
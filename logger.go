@@ -0,0 +1,63 @@
+// 日志
+//
+// 连接池默认不输出任何日志，调用 RegisterLogger 或池的成员函数 SetLogger 设置 Logger 后，
+// 会记录拨号失败、连接剔除（及原因）、池满丢弃、关闭进度和连接泄漏等。
+// 子目录 grpcpoolslog、grpcpoolzap 和 grpcpoollogrus 分别提供了 log/slog、zap 和 logrus 的适配。
+
+package grpcpool
+
+// 结构化日志接口，keyvals 为交替出现的键和值，
+// 与 log/slog 的 *slog.Logger 的同名成员函数兼容，*slog.Logger 可直接作为 Logger 使用。
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+var (
+	logger Logger
+)
+
+// 设置全局的 Logger，作用于所有未调用成员函数 SetLogger 的池，
+// 和 RegisterMetricObserver 一样，应在连接池投入使用前调用。
+func RegisterLogger(l Logger) {
+	logger = l
+}
+
+// 设置只作用于本池的 Logger，传 nil 则恢复使用 RegisterLogger 设置的，应在连接池投入使用前调用
+func (this *GRPCPool) SetLogger(l Logger) {
+	this.logger = l
+}
+
+// 取得本池实际使用的 Logger，可能为 nil
+func (this *GRPCPool) GetLogger() Logger {
+	if this.logger != nil {
+		return this.logger
+	}
+	return logger
+}
+
+func (this *GRPCPool) logDebug(msg string, keyvals ...interface{}) {
+	if l := this.GetLogger(); l != nil {
+		l.Debug(msg, append([]interface{}{"endpoint", this.endpoint}, keyvals...)...)
+	}
+}
+
+func (this *GRPCPool) logInfo(msg string, keyvals ...interface{}) {
+	if l := this.GetLogger(); l != nil {
+		l.Info(msg, append([]interface{}{"endpoint", this.endpoint}, keyvals...)...)
+	}
+}
+
+func (this *GRPCPool) logWarn(msg string, keyvals ...interface{}) {
+	if l := this.GetLogger(); l != nil {
+		l.Warn(msg, append([]interface{}{"endpoint", this.endpoint}, keyvals...)...)
+	}
+}
+
+func (this *GRPCPool) logError(msg string, keyvals ...interface{}) {
+	if l := this.GetLogger(); l != nil {
+		l.Error(msg, append([]interface{}{"endpoint", this.endpoint}, keyvals...)...)
+	}
+}
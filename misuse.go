@@ -0,0 +1,56 @@
+// 误用检测
+//
+// 对同一连接重复 Put、或将连接 Put 到取出它的池以外的池，会破坏 used 和 idle 的计数，
+// 并可能把同一连接同时交给两个协程。Put 会识别这两种误用，拒绝并返回 CONN_MISUSED 和 *MisuseError，
+// 计入度量数据的 PutMisuse（见 MisuseObserver），并记错误日志。
+// 用 -tags grpcpooldebug 编译时改为 panic，以便在测试中尽早暴露问题。
+//
+// 注意：连接被 Put 后又被其它协程 Get 取走，此时对它的重复 Put 无法与正常的 Put 区分。
+// 需要识别这种过期的归还时，使用 Lease（或 PutGeneration），它按取走的序号检查，返回 ErrStalePut。
+
+package grpcpool
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrDoublePut  = errors.New("connection put twice")
+	ErrForeignPut = errors.New("connection put into a pool it does not belong to")
+	ErrStalePut   = errors.New("connection put after it was borrowed again")
+)
+
+// 误用观察者，MetricObserver 的实现如果同时实现了本接口，则还会收到误用的计数
+type MisuseObserver interface {
+	IncPutMisuse() int32 // 误用的还池数增一
+}
+
+// 误用错误，可用 errors.Is 判断是 ErrDoublePut、ErrForeignPut 还是 ErrStalePut
+type MisuseError struct {
+	Err      error     // ErrDoublePut、ErrForeignPut 或 ErrStalePut
+	ConnID   uint64    // 被误用的连接
+	Endpoint string    // 被 Put 到的池的端点
+	State    ConnState // Put 时连接的状态
+}
+
+func (this *MisuseError) Error() string {
+	return fmt.Sprintf("grpcpool misuse: %s (conn:%d, endpoint:%s, state:%s)", this.Err.Error(), this.ConnID, this.Endpoint, this.State)
+}
+
+func (this *MisuseError) Unwrap() error {
+	return this.Err
+}
+
+// 处理一次误用：计数、记日志，debug 模式下 panic，返回 CONN_MISUSED 和 *MisuseError
+func (this *GRPCPool) misuse(conn *GRPCConn, err error) (uint, error) {
+	misuseErr := &MisuseError{Err: err, ConnID: conn.id, Endpoint: this.endpoint, State: conn.GetPoolState()}
+	if mo, ok := this.GetMetricObserver().(MisuseObserver); ok {
+		mo.IncPutMisuse()
+	}
+	this.logError("grpcpool misuse", "conn", conn.id, "state", misuseErr.State.String(), "error", err)
+	if PANIC_ON_MISUSE {
+		panic(misuseErr)
+	}
+	return CONN_MISUSED, misuseErr
+}
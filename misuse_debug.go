@@ -0,0 +1,7 @@
+//go:build grpcpooldebug
+// +build grpcpooldebug
+
+package grpcpool
+
+// 误用时 panic（用 -tags grpcpooldebug 编译）
+const PANIC_ON_MISUSE = true
@@ -0,0 +1,28 @@
+//go:build grpcpooldebug
+// +build grpcpooldebug
+
+package grpcpool_test
+
+import (
+	"errors"
+	"testing"
+)
+import (
+	"github.com/eyjian/grpcpool"
+)
+
+// go test -tags grpcpooldebug
+func TestMisusePanic(t *testing.T) {
+	_, pool, _, _ := newTestPool(t, 1, 2, 4)
+
+	conn := mustGet(t, pool)
+	pool.Put(conn)
+	defer func() {
+		err, ok := recover().(error)
+		if !ok || !errors.Is(err, grpcpool.ErrDoublePut) {
+			t.Errorf("recovered %v, want ErrDoublePut", err)
+		}
+	}()
+	pool.Put(conn)
+	t.Error("second Put did not panic")
+}
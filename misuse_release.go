@@ -0,0 +1,7 @@
+//go:build !grpcpooldebug
+// +build !grpcpooldebug
+
+package grpcpool
+
+// 误用时只返回错误，用 -tags grpcpooldebug 编译时改为 panic
+const PANIC_ON_MISUSE = false
@@ -0,0 +1,120 @@
+// 空闲连接的回收
+//
+// 空闲连接数超过 initSize 时，最久未用的连接空闲超过 idleTimeout 后被关闭，
+// 超过 idleSize 时空闲超过 peakTimeout 后被关闭（Put 时也会检查，见 idleExpired）。
+// releaseIdleCoroutine 不定时轮询，而是按最久未用的连接计算下次到期的时间，只在到期时被唤醒：
+// 1) 空闲连接数不超过 initSize 时不计时，直到 Put 使其超过（kickReaper）；
+// 2) 检查时连接留在列表中，到期的才取出并剔除，不经过 Get 和 Put，不影响 used 及取还相关的度量数据；
+// 3) 使用中的连接数不少于空闲连接数时表明正忙，推迟 releaseIdleInterval 再检查。
+// 存活时长超过 maxLifetime 的在归还时关闭，releaseIdleCoroutine 只顺带关闭它检查到的。
+
+package grpcpool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+func (this *GRPCPool) releaseIdleCoroutine() {
+	defer this.wg.Done()
+
+	for {
+		// 先置 0 再读取空闲连接数，使 kickReaper 不会错过唤醒
+		atomic.StoreInt64(&this.reapAt, 0)
+		var timer Timer
+		var timerChan <-chan time.Time // 为 nil 时只等 reapChan
+		if wait, ok := this.nextReap(); ok {
+			atomic.StoreInt64(&this.reapAt, this.now().Add(wait).UnixNano())
+			timer = this.newTimer(wait)
+			timerChan = timer.C()
+		}
+
+		select {
+		case <-this.done:
+			stopTimer(timer)
+			return
+		case <-this.clockChan:
+			// 改用新时钟重新计时
+			stopTimer(timer)
+			continue
+		case <-this.reapChan:
+			// 重新计时，停止原来的定时器
+			stopTimer(timer)
+			continue
+		case <-timerChan:
+		}
+		this.releaseIdle()
+	}
+}
+
+// 计算距最久未用的空闲连接到期的时长，不需要计时时 ok 为 false
+func (this *GRPCPool) nextReap() (wait time.Duration, ok bool) {
+	idle := this.GetIdle()
+	if idle <= this.GetInitSize() {
+		return 0, false
+	}
+	if this.GetUsed() >= idle {
+		// 正忙
+		return this.releaseIdleInterval, true
+	}
+	conn, _ := this.idleConns.coldest()
+	if conn == nil {
+		return 0, false
+	}
+
+	timeout := this.idleTimeout
+	if idle > this.GetIdleSize() && this.peakTimeout < timeout {
+		timeout = this.peakTimeout
+	}
+	// 与 idleExpired 一致：按秒计，空闲时长超过 timeout 才到期
+	utime := atomic.LoadInt64(&conn.utime) / int64(time.Second)
+	wait = time.Unix(utime+int64(timeout)+1, 0).Sub(this.now())
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, true
+}
+
+// 空闲连接数 idle 超过 initSize 时，如果 releaseIdleCoroutine 未在计时，或空闲连接数刚超过 idleSize（改用 peakTimeout），则唤醒它重新计时
+func (this *GRPCPool) kickReaper(idle int32) {
+	if idle <= this.GetInitSize() {
+		return
+	}
+	if atomic.LoadInt64(&this.reapAt) != 0 && idle != this.GetIdleSize()+1 {
+		return
+	}
+	select {
+	case this.reapChan <- struct{}{}:
+	default:
+	}
+}
+
+// 从最久未用的开始检查空闲连接，剔除空闲太久或存活太久的，直到遇到未到期的
+func (this *GRPCPool) releaseIdle() {
+	initSize := this.GetInitSize()
+	idleSize := this.GetIdle()
+	usedSize := this.GetUsed()
+	// 大量在使用时，表明正忙着
+	if idleSize > initSize && usedSize < idleSize {
+		for i := 0; i < int(idleSize); i++ {
+			conn, shard := this.idleConns.coldest()
+			if conn == nil {
+				break
+			}
+			now := this.now()
+			reason, ok := EVICT_LIFETIME, this.lifetimeExceeded(conn, now)
+			if !ok {
+				utime := atomic.LoadInt64(&conn.utime) / int64(time.Second)
+				reason, ok = this.idleExpired(this.GetIdle(), utime, now)
+			}
+			if !ok {
+				// 最久未用的都未到期，其余的也不会
+				break
+			}
+			// 检查期间可能已被 Get 取走
+			if shard.remove(conn) {
+				this.evictIdle(conn, reason)
+			}
+		}
+	}
+}
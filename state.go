@@ -0,0 +1,182 @@
+// 连接在池中的状态
+//
+// 每个连接都经过以下状态，转换用 CAS 完成，同一连接的同一转换只有一个协程能成功：
+//
+//	DIALING --> LEASED <--> IDLE
+//	   |          |          |
+//	   |          +--> EVICTING <--+
+//	   |                 |
+//	   +------------> CLOSED
+//
+// 1) DIALING：Get 或后台协程正在为它拨号，拨号成功后转为 LEASED（Get）或 IDLE（后台协程补充），失败或超出 peakSize 时转为 CLOSED；
+// 2) IDLE：在池中（或被 releaseIdleCoroutine 暂时取出检查）；
+// 3) LEASED：被 Get 取走，尚未 Put；
+// 4) EVICTING：正在被剔除（见 evict），在 closeCoroutine 中关闭后转为 CLOSED（见 closer.go）。
+//
+// 池的 used 为 DIALING 和 LEASED 状态的连接数，idle 为 IDLE 状态的连接数，
+// 二者由各状态的连接数得出，各状态的连接数只在状态转换时增减，因此总与连接的实际状态一致。
+// 各状态的连接数按空闲连接分片分组计数（见 connCounters），读取时求和；
+// peakSize 的限制则由全局的 live（DIALING、LEASED 和 IDLE 状态的连接数）保证，它只在拨号和剔除时增减。
+
+package grpcpool
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// 连接在池中的状态
+type ConnState int32
+
+const (
+	STATE_DIALING  ConnState = 0 // 正在拨号
+	STATE_IDLE     ConnState = 1 // 空闲，在池中
+	STATE_LEASED   ConnState = 2 // 被 Get 取走
+	STATE_EVICTING ConnState = 3 // 正在被剔除
+	STATE_CLOSED   ConnState = 4 // 已关闭
+	MAX_CONN_STATE           = 5
+)
+
+func (this ConnState) String() string {
+	switch this {
+	case STATE_DIALING:
+		return "dialing"
+	case STATE_IDLE:
+		return "idle"
+	case STATE_LEASED:
+		return "leased"
+	case STATE_EVICTING:
+		return "evicting"
+	case STATE_CLOSED:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// 取得连接在池中的状态
+func (this *GRPCConn) GetPoolState() ConnState {
+	return ConnState(atomic.LoadInt32(&this.state))
+}
+
+// 一组连接计数，每个空闲连接分片一组，状态转换时只增减当前协程所在分片的那组（见 idleList.local），
+// 以免多核下所有 Get 和 Put 都争用同一缓存行；各组的值可能为负，总和才是实际的连接数
+type connCounters struct {
+	states [MAX_CONN_STATE]int32       // 各状态的连接数（CLOSED 不计）
+	_      [64 - 4*MAX_CONN_STATE]byte // 填充到缓存行大小（64 字节）
+}
+
+// 创建一个 DIALING 状态的连接（尚未拨号），返回它和创建后的连接数（DIALING、LEASED 和 IDLE 状态的）
+func (this *GRPCPool) newConn() (*GRPCConn, int32) {
+	conn := new(GRPCConn)
+	conn.id = atomic.AddUint64(&lastConnID, 1)
+	conn.endpoint = this.endpoint
+	conn.pool = this
+	conn.state = int32(STATE_DIALING)
+	atomic.AddInt32(&this.idleConns.local().states[STATE_DIALING], 1)
+	if mo := this.GetMetricObserver(); mo != nil {
+		mo.IncUsed()
+	}
+	return conn, atomic.AddInt32(&this.live, 1)
+}
+
+// 将连接的状态从 from 转为 to，并相应增减各状态的连接数和度量数据观察者的 used 和 idle，
+// 连接当前不是 from 状态时（已被其它协程转换）返回 false
+func (this *GRPCPool) setState(conn *GRPCConn, from, to ConnState) bool {
+	if !atomic.CompareAndSwapInt32(&conn.state, int32(from), int32(to)) {
+		return false
+	}
+	counters := this.idleConns.local()
+	if to != STATE_CLOSED {
+		atomic.AddInt32(&counters.states[to], 1)
+	}
+	atomic.AddInt32(&counters.states[from], -1)
+
+	if mo := this.GetMetricObserver(); mo != nil {
+		// DIALING 和 LEASED 都计入 used，二者间的转换不增减
+		if isUsedState(to) && !isUsedState(from) {
+			mo.IncUsed()
+		} else if isUsedState(from) && !isUsedState(to) {
+			mo.DecUsed()
+		}
+		if to == STATE_IDLE {
+			mo.IncIdle()
+		} else if from == STATE_IDLE {
+			mo.DecIdle()
+		}
+	}
+	// 只在拨号、剔除和关闭时增减，不在 Get 和 Put 的常规路径上
+	if to == STATE_EVICTING || to == STATE_CLOSED {
+		if from != STATE_EVICTING {
+			atomic.AddInt32(&this.live, -1)
+		}
+	}
+	return true
+}
+
+func isUsedState(state ConnState) bool {
+	return state == STATE_DIALING || state == STATE_LEASED
+}
+
+// 取得各状态的连接数（CLOSED 不计），下标为 ConnState
+func (this *GRPCPool) GetStateCounts() [MAX_CONN_STATE]int32 {
+	var counts [MAX_CONN_STATE]int32
+	for k := range this.idleConns.counters {
+		counters := &this.idleConns.counters[k]
+		for i := range counts {
+			counts[i] += atomic.LoadInt32(&counters.states[i])
+		}
+	}
+	return counts
+}
+
+// 检查各状态的连接数（used 和 idle 由它们得出）、所有连接的记录和空闲连接列表是否一致，一致时返回 nil，
+// 否则返回列出所有不一致之处的错误。
+// 各项不是在同一时刻取得的，只在没有并发的 Get、Put 和后台协程操作时才精确，
+// 供测试和调试接口使用。
+func (this *GRPCPool) CheckInvariants() error {
+	var problems []string
+	counts := this.GetStateCounts()
+
+	for state, count := range counts {
+		if count < 0 {
+			problems = append(problems, fmt.Sprintf("%s count %d < 0", ConnState(state), count))
+		}
+	}
+
+	// 拨号成功后才记录，剔除时即不再记录，因此记录中只有 IDLE 和 LEASED 的
+	var recorded [MAX_CONN_STATE]int32
+	for _, conn := range this.getConns() {
+		recorded[conn.GetPoolState()]++
+	}
+	for _, state := range []ConnState{STATE_DIALING, STATE_EVICTING, STATE_CLOSED} {
+		if recorded[state] > 0 {
+			problems = append(problems, fmt.Sprintf("%d %s connections recorded", recorded[state], state))
+		}
+	}
+	for _, state := range []ConnState{STATE_IDLE, STATE_LEASED} {
+		if recorded[state] != counts[state] {
+			problems = append(problems, fmt.Sprintf("%d %s connections recorded, but %s count is %d", recorded[state], state, state, counts[state]))
+		}
+	}
+
+	conns := this.idleConns.snapshot()
+	for _, conn := range conns {
+		if state := conn.GetPoolState(); state != STATE_IDLE {
+			problems = append(problems, fmt.Sprintf("conn %d in idle list is %s", conn.id, state))
+		}
+	}
+	if int32(len(conns)) != counts[STATE_IDLE] {
+		problems = append(problems, fmt.Sprintf("%d connections in idle list, but idle state count is %d", len(conns), counts[STATE_IDLE]))
+	}
+	if atomic.LoadInt32(&this.closed) == 1 && len(conns) > 0 {
+		problems = append(problems, fmt.Sprintf("%d connections in idle list of closed pool", len(conns)))
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(fmt.Sprintf("pool for %s is inconsistent: %s", this.endpoint, strings.Join(problems, "; ")))
+}
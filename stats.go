@@ -0,0 +1,167 @@
+// 连接池的统计快照
+//
+// 分别调用 GetUsed、GetIdle 和各个 Zero* 函数，两次调用之间的计数可能被遗漏或重复计入，
+// Stats 和 DefaultMetricObserver 的成员函数 Snapshot 一次调用即取得全部度量数据。
+// 为不拖慢 Get 和 Put，各项是分别原子地读取的，不是严格同一时刻的值，但清 0 时不会丢失计数。
+// Used 和 Idle 在一趟中从各状态的连接数得出，但快照期间仍在转换状态的连接可能被计入两次或不计入，是近似值。
+
+package grpcpool
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// 可取得度量数据快照的 MetricObserver，如 DefaultMetricObserver
+type MetricSnapshotter interface {
+	Snapshot(reset bool) Metric
+}
+
+// 连接池的统计快照
+type Stats struct {
+	Metric Metric // 度量数据，Used 和 Idle 取自池本身，其余取自池的 MetricObserver（实现了 MetricSnapshotter 时）
+
+	Endpoint    string
+	InitSize    int32
+	IdleSize    int32
+	PeakSize    int32
+	IdleTimeout int32 // 单位：秒
+	PeakTimeout int32 // 单位：秒
+	MaxLifetime int32 // 单位：秒，0 表示不限制
+	Shards      int   // 空闲连接列表的分片数（见 NewShardedGRPCPool）
+	Closed      bool
+	AccessTime  int64           // 最近一次调用 Get 或 Put 的时间（Unix 时间戳，单位：秒）
+	ConnAges    []time.Duration // 所有未关闭连接（包括使用中的和空闲的）的已存活时长，从长到短排列
+}
+
+// 取得连接池的统计快照
+func (this *GRPCPool) Stats() Stats {
+	var stats Stats
+	if snapshotter, ok := this.GetMetricObserver().(MetricSnapshotter); ok {
+		stats.Metric = snapshotter.Snapshot(false)
+	}
+	stats.Endpoint = this.endpoint
+	stats.InitSize = this.GetInitSize()
+	stats.IdleSize = this.GetIdleSize()
+	stats.PeakSize = this.GetPeakSize()
+	stats.IdleTimeout = this.idleTimeout
+	stats.PeakTimeout = this.peakTimeout
+	stats.MaxLifetime = this.maxLifetime
+	stats.Shards = len(this.idleConns.shards)
+	stats.Closed = atomic.LoadInt32(&this.closed) == 1
+	stats.AccessTime = this.GetAccessTime()
+
+	// 一趟取得各状态的连接数，Used 和 Idle 由同一组值得出（见文件头的说明，仍是近似值）
+	counts := this.GetStateCounts()
+	stats.Metric.Used = counts[STATE_DIALING] + counts[STATE_LEASED]
+	stats.Metric.Idle = counts[STATE_IDLE]
+	stats.Metric.CloseBacklog = this.GetCloseBacklog()
+
+	// 连接可能在取得上面的计数之后被创建或关闭，ConnAges 的个数不一定等于 Used 与 Idle 之和
+	this.connsMutex.Lock()
+	now := this.now()
+	stats.ConnAges = make([]time.Duration, 0, len(this.conns))
+	for conn := range this.conns {
+		stats.ConnAges = append(stats.ConnAges, now.Sub(conn.ctime))
+	}
+	this.connsMutex.Unlock()
+
+	sort.Slice(stats.ConnAges, func(i, j int) bool {
+		return stats.ConnAges[i] > stats.ConnAges[j]
+	})
+	return stats
+}
+
+// 记录新建的连接
+func (this *GRPCPool) rememberConn(conn *GRPCConn) {
+	this.connsMutex.Lock()
+	defer this.connsMutex.Unlock()
+	this.conns[conn] = struct{}{}
+}
+
+// 忘记已关闭的连接
+func (this *GRPCPool) forgetConn(conn *GRPCConn) {
+	this.connsMutex.Lock()
+	defer this.connsMutex.Unlock()
+	delete(this.conns, conn)
+}
+
+// 取得所有未关闭的连接
+func (this *GRPCPool) getConns() []*GRPCConn {
+	this.connsMutex.Lock()
+	defer this.connsMutex.Unlock()
+	conns := make([]*GRPCConn, 0, len(this.conns))
+	for conn := range this.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// 取得度量数据的快照，reset 为 true 时同时将除 Used、Idle 和 CloseBacklog 以外的计数和耗时分布清 0（返回清 0 前的值），
+// 各项分别原子地读取（清 0 时原子地交换），不加锁以免拖慢 Get 和 Put：
+// 快照期间仍在进行的操作可能部分计入本次、部分计入下次，但不会丢失
+func (this *DefaultMetricObserver) Snapshot(reset bool) Metric {
+	load := atomic.LoadInt32
+	if reset {
+		load = func(addr *int32) int32 {
+			return atomic.SwapInt32(addr, 0)
+		}
+	}
+
+	var metric Metric
+	metric.Used = atomic.LoadInt32(&this.metric.Used)
+	metric.Idle = atomic.LoadInt32(&this.metric.Idle)
+	metric.CloseBacklog = atomic.LoadInt32(&this.metric.CloseBacklog)
+	metric.DialRefused = load(&this.metric.DialRefused)
+	metric.DialTimeout = load(&this.metric.DialTimeout)
+	metric.DialSuccess = load(&this.metric.DialSuccess)
+	metric.DialError = load(&this.metric.DialError)
+	metric.GetSuccess = load(&this.metric.GetSuccess)
+	metric.GetEmpty = load(&this.metric.GetEmpty)
+	metric.GetLimited = load(&this.metric.GetLimited)
+	metric.PutSuccess = load(&this.metric.PutSuccess)
+	metric.PutFull = load(&this.metric.PutFull)
+	metric.PutClose = load(&this.metric.PutClose)
+	metric.PutOld = load(&this.metric.PutOld)
+	metric.PutIdle = load(&this.metric.PutIdle)
+	metric.PutMisuse = load(&this.metric.PutMisuse)
+	metric.EvictHealth = load(&this.metric.EvictHealth)
+	metric.EvictBroken = load(&this.metric.EvictBroken)
+	metric.EvictDisconnected = load(&this.metric.EvictDisconnected)
+	metric.GetWait.restore(this.metric.GetWait.Snapshot(reset))
+	metric.DialDuration.restore(this.metric.DialDuration.Snapshot(reset))
+	metric.HoldTime.restore(this.metric.HoldTime.Snapshot(reset))
+	metric.CloseDuration.restore(this.metric.CloseDuration.Snapshot(reset))
+	return metric
+}
+
+// 两个快照之差（this - prev），用于计算两次快照之间的增量，
+// Used、Idle 和 CloseBacklog 为瞬时值，取 this 的
+func (this *Metric) Delta(prev *Metric) Metric {
+	var delta Metric
+	delta.Used = this.Used
+	delta.Idle = this.Idle
+	delta.CloseBacklog = this.CloseBacklog
+	delta.DialRefused = this.DialRefused - prev.DialRefused
+	delta.DialTimeout = this.DialTimeout - prev.DialTimeout
+	delta.DialSuccess = this.DialSuccess - prev.DialSuccess
+	delta.DialError = this.DialError - prev.DialError
+	delta.GetSuccess = this.GetSuccess - prev.GetSuccess
+	delta.GetEmpty = this.GetEmpty - prev.GetEmpty
+	delta.GetLimited = this.GetLimited - prev.GetLimited
+	delta.PutSuccess = this.PutSuccess - prev.PutSuccess
+	delta.PutFull = this.PutFull - prev.PutFull
+	delta.PutClose = this.PutClose - prev.PutClose
+	delta.PutOld = this.PutOld - prev.PutOld
+	delta.PutIdle = this.PutIdle - prev.PutIdle
+	delta.PutMisuse = this.PutMisuse - prev.PutMisuse
+	delta.EvictHealth = this.EvictHealth - prev.EvictHealth
+	delta.EvictBroken = this.EvictBroken - prev.EvictBroken
+	delta.EvictDisconnected = this.EvictDisconnected - prev.EvictDisconnected
+	delta.GetWait.restore(this.GetWait.Snapshot(false).Sub(prev.GetWait.Snapshot(false)))
+	delta.DialDuration.restore(this.DialDuration.Snapshot(false).Sub(prev.DialDuration.Snapshot(false)))
+	delta.HoldTime.restore(this.HoldTime.Snapshot(false).Sub(prev.HoldTime.Snapshot(false)))
+	delta.CloseDuration.restore(this.CloseDuration.Snapshot(false).Sub(prev.CloseDuration.Snapshot(false)))
+	return delta
+}
@@ -5,6 +5,7 @@ import (
     "context"
     "flag"
     "fmt"
+    "net/http"
     "os"
     "runtime/pprof"
     "runtime/trace"
@@ -32,6 +33,14 @@ var (
     tick = flag.Uint("tick", 0, "Tick number to print, example: -tick=10000.")
     timeout = flag.Uint("timeout", 2000, "Timeout in milliseconds.")
 
+    limiter = flag.String("limiter", "", "Adaptive concurrency limiter of gRPC pool: aimd or gradient, empty means no limit.")
+
+    healthService = flag.String("health_service", "", "Service name of gRPC health check, empty means the whole server.")
+    healthInterval = flag.Uint("health_interval", 0, "Interval in seconds of gRPC health check on idle connections, 0 means disabled.")
+    watchState = flag.Bool("watch_state", false, "Watch connectivity state of pooled connections and evict broken ones.")
+
+    debugAddr = flag.String("debug_addr", "", "Address of HTTP debug server showing pool state at /debug/grpcpool, example: -debug_addr=127.0.0.1:8080.")
+
     withblock = flag.Bool("withblock", false, "gRPC dial to server withblock.")
     printInterceptor = flag.Bool("print_interceptor", false, "Print interceptor information.")
 )
@@ -121,6 +130,25 @@ func main() {
         int32(*idleSize),
         int32(*peakSize),
         dialOpts...)
+    if *limiter == "aimd" {
+        gRPCPool.SetLimiter(grpcpool.NewAIMDLimiter(int32(*idleSize), int32(*initSize), int32(*peakSize)))
+    } else if *limiter == "gradient" {
+        gRPCPool.SetLimiter(grpcpool.NewGradientLimiter(int32(*idleSize), int32(*initSize), int32(*peakSize)))
+    } else if *limiter != "" {
+        fmt.Printf("Parameter[-limiter] is invalid: %s.\n", *limiter)
+        os.Exit(1)
+    }
+    if *watchState {
+        gRPCPool.EnableStateWatcher()
+    }
+    if *healthInterval > 0 {
+        gRPCPool.EnableHealthCheck(*healthService, time.Second*time.Duration(*healthInterval), time.Millisecond*time.Duration(*timeout))
+    }
+    if *debugAddr != "" {
+        mux := http.NewServeMux()
+        mux.Handle("/debug/grpcpool", grpcpool.NewDebugHandler())
+        go http.ListenAndServe(*debugAddr, mux)
+    }
     numPendingRequests = int32(*numRequests)
     wg.Add(int(*numConcurrency))
     grpcpool.RegisterMetricObserver(&defaultMetricObserver)
@@ -146,6 +174,9 @@ func main() {
     } else {
         fmt.Printf("QPS: %d (Total: %d, Seconds: %d, Success: %d, PoolFailed: %d, CallFailed: %d) *\n", 0, numFinishRequests, s, numSuccessRequests, numPoolFailedRequests, numCallFailedRequests)
     }
+    if healthStatus, ok := gRPCPool.GetHealthStatus(); ok {
+        fmt.Printf("Health: %s (Checked: %d, Failed: %d, LastError: %s)\n", healthStatus.Status, healthStatus.Checked, healthStatus.Failed, healthStatus.LastError)
+    }
 }
 
 func metricCoroutine() {
@@ -157,21 +188,12 @@ func metricCoroutine() {
             break
         }
 
-        used := defaultMetricObserver.GetUsed()
-        idle := defaultMetricObserver.GetIdle()
-        dialRefused := defaultMetricObserver.ZeroDialRefused()
-        dialTimeout := defaultMetricObserver.ZeroDialTimeout()
-        dialSuccess := defaultMetricObserver.ZeroDialSuccess()
-        dialError := defaultMetricObserver.ZeroDialError()
-        getSuccess := defaultMetricObserver.ZeroGetSuccess()
-        getEmpty := defaultMetricObserver.ZeroGetEmpty()
-
-        if getSuccess > 0 || getEmpty > 0 || dialSuccess > 0 || dialRefused > 0 || dialTimeout > 0 || dialError > 0 {
-            putSuccess := defaultMetricObserver.ZeroPutSuccess()
-            putFull := defaultMetricObserver.ZeroPutFull()
-            putClose := defaultMetricObserver.ZeroPutClose()
-            putOld := defaultMetricObserver.ZeroPutOld()
-            putIdle := defaultMetricObserver.ZeroPutIdle()
+        // 一次取得一致的快照并清 0
+        metric := defaultMetricObserver.Snapshot(true)
+        if metric.GetSuccess > 0 || metric.GetEmpty > 0 || metric.GetLimited > 0 || metric.DialSuccess > 0 || metric.DialRefused > 0 || metric.DialTimeout > 0 || metric.DialError > 0 {
+            getWait := metric.GetWait.Snapshot(false)
+            dialDuration := metric.DialDuration.Snapshot(false)
+            holdTime := metric.HoldTime.Snapshot(false)
             fmt.Printf("Used:%d,"+
                 "Idle:%d,"+
                 "DialRefused:%d,"+
@@ -180,24 +202,34 @@ func metricCoroutine() {
                 "DialError:%d,"+
                 "GetSuccess:%d,"+
                 "GetEmpty:%d,"+
+                "GetLimited:%d,"+
                 "PutSuccess:%d,"+
                 "PutFull:%d,"+
                 "PutClose:%d,"+
                 "PutOld:%d,"+
-                "PutIdle:%d\n",
-                used,
-                idle,
-                dialRefused,
-                dialTimeout,
-                dialSuccess,
-                dialError,
-                getSuccess,
-                getEmpty,
-                putSuccess,
-                putFull,
-                putClose,
-                putOld,
-                putIdle)
+                "PutIdle:%d,"+
+                "PutMisuse:%d,"+
+                "GetWait(P50/P99/Max):%s/%s/%s,"+
+                "DialDuration(P50/P99/Max):%s/%s/%s,"+
+                "HoldTime(P50/P99/Max):%s/%s/%s\n",
+                metric.Used,
+                metric.Idle,
+                metric.DialRefused,
+                metric.DialTimeout,
+                metric.DialSuccess,
+                metric.DialError,
+                metric.GetSuccess,
+                metric.GetEmpty,
+                metric.GetLimited,
+                metric.PutSuccess,
+                metric.PutFull,
+                metric.PutClose,
+                metric.PutOld,
+                metric.PutIdle,
+                metric.PutMisuse,
+                getWait.Percentile(0.5), getWait.Percentile(0.99), getWait.Max,
+                dialDuration.Percentile(0.5), dialDuration.Percentile(0.99), dialDuration.Max,
+                holdTime.Percentile(0.5), holdTime.Percentile(0.99), holdTime.Max)
         }
     }
 }
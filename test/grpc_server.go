@@ -12,12 +12,16 @@ import (
 )
 import (
     "google.golang.org/grpc"
+    "google.golang.org/grpc/health"
+    "google.golang.org/grpc/keepalive"
+    healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 var (
     help = flag.Bool("h", false, "Display a help message and exit.")
     port = flag.Uint("port", 2020, "Port of gRPC server.")
     printInterceptor = flag.Bool("print_interceptor", false, "Print interceptor information.")
+    maxConnAge = flag.Duration("max_conn_age", 0, "Max age of a connection before the server sends GOAWAY, 0 means infinity.")
 )
 
 func main() {
@@ -34,13 +38,26 @@ func main() {
     }
 
     fmt.Printf("gRPC listen on %d, PID is %d\n", *port, os.Getpid())
-    server := grpc.NewServer(
+    opts := []grpc.ServerOption{
         grpc.ChainUnaryInterceptor(
             unaryInterceptor),
         grpc.ServerOption(
             grpc.ConnectionTimeout(time.Millisecond*time.Duration(1000))),
-        grpc.InTapHandle(serverInHandle),)
+        grpc.InTapHandle(serverInHandle),
+    }
+    if *maxConnAge > 0 {
+        // 连接存活超过 max_conn_age 后服务端发送 GOAWAY，用于验证连接池对 GOAWAY 的处理（客户端需开启 EnableStateWatcher）
+        opts = append(opts, grpc.KeepaliveParams(keepalive.ServerParameters{
+            MaxConnectionAge: *maxConnAge,
+            MaxConnectionAgeGrace: time.Second,
+        }))
+    }
+    server := grpc.NewServer(opts...)
     RegisterHelloServiceServer(server, &gRPCHandler{})
+    // 健康检查服务（grpc.health.v1.Health），供连接池的健康检查使用
+    healthServer := health.NewServer()
+    healthServer.SetServingStatus("main.HelloService", healthpb.HealthCheckResponse_SERVING)
+    healthpb.RegisterHealthServer(server, healthServer)
     err = server.Serve(listen)
     if err != nil {
         fmt.Printf("Started gRPC server failed: %s\n", err.Error())
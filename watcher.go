@@ -0,0 +1,140 @@
+// 连接状态监视
+//
+// 池中的空闲连接不会被任何人观察，一个已进入 TransientFailure 的连接
+// 会一直留在池中，直到被取走使用时才发现。开启连接状态监视后，
+// 每个连接都有一个协程通过 ClientConn.WaitForStateChange 跟踪其状态，
+// 连接进入 TransientFailure 或 Shutdown 状态时被标记为 broken，
+// 从 Ready 转为 Idle 或 Connecting 时（通常是服务端发送了 HTTP/2 GOAWAY，如设置了 MaxConnectionAge）被标记为 disconnected（GOAWAY 以外的连接断开也是如此，无法区分），
+// 这种连接会自行重连，但可能连到其它后端或处于降级状态：
+// 二者空闲的都会被立即剔除，使用中的在归还时剔除，并补充新连接以维持 initSize 个连接。
+// 被池剔除或被使用者关闭的连接进入 Shutdown 是正常的关闭，不会被标记。
+// 补充后间隔一段时间（按池的 Clock 计时）再处理下一次通知，避免服务端不可用时反复拨号。
+
+package grpcpool
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+import (
+	"google.golang.org/grpc/connectivity"
+)
+
+// 补充新连接时每次拨号的超时时长（见 replenish）
+const REPLENISH_DIAL_TIMEOUT = time.Second
+
+// 开启连接状态监视，只对开启后新建的连接生效，应在连接池投入使用前调用，重复调用无效。
+func (this *GRPCPool) EnableStateWatcher() {
+	if !atomic.CompareAndSwapInt32(&this.watchState, 0, 1) {
+		return
+	}
+	this.startCoroutine(this.stateWatchCoroutine)
+}
+
+// 跟踪单个连接的状态，连接被关闭（进入 Shutdown 状态）或池被关闭后退出，
+// state 为拨号返回时取得的状态，以免协程开始运行前连接已离开 Ready 而漏掉。
+// 注意只有拨号返回时已为 Ready（如使用了 grpc.WithBlock）才能保证这一点：
+// 默认的非阻塞拨号返回时通常为 Connecting，如果连接在本协程观察到 Ready 之前就已经 Ready 又断开，
+// 醒来时看到的是 Connecting 到 Idle 或 Connecting，无法识别为断开，这种连接只能由健康检查或进入 TransientFailure 时发现
+func (this *GRPCPool) watchCoroutine(conn *GRPCConn, state connectivity.State) {
+	defer this.wg.Done()
+	client := conn.GetClient()
+	for {
+		if state == connectivity.Shutdown && conn.IsClosed() {
+			// 被池剔除或被使用者关闭
+			return
+		}
+		if state == connectivity.TransientFailure || state == connectivity.Shutdown {
+			atomic.StoreInt32(&conn.broken, 1)
+			this.notifyBroken()
+			return
+		}
+		if !client.WaitForStateChange(this.doneCtx, state) {
+			// 池被关闭
+			return
+		}
+		// 重连可能很快，醒来时可能已回到 Ready，因此以离开 Ready 为准
+		prev := state
+		state = client.GetState()
+		if prev == connectivity.Ready && state != connectivity.TransientFailure && state != connectivity.Shutdown {
+			atomic.StoreInt32(&conn.disconnected, 1)
+			this.logInfo("grpcpool connection disconnected", "conn", conn.id, "state", state.String())
+			this.notifyBroken()
+			return
+		}
+	}
+}
+
+// 通知 stateWatchCoroutine 剔除 broken 和 disconnected 的空闲连接并补充新连接，
+// 在连接被标记时和使用中的被标记的连接归还时调用
+func (this *GRPCPool) notifyBroken() {
+	select {
+	case this.brokenChan <- struct{}{}:
+	default:
+	}
+}
+
+// 剔除池中 broken 和 disconnected 的空闲连接，并补充新连接
+func (this *GRPCPool) stateWatchCoroutine() {
+	defer this.wg.Done()
+
+	for {
+		select {
+		case <-this.done:
+			return
+		case <-this.brokenChan:
+		}
+
+		// 使用中的在归还时剔除
+		for _, conn := range this.idleConns.snapshot() {
+			if conn.IsBroken() && this.removeIdle(conn) {
+				this.evict(conn, STATE_IDLE, EVICT_BROKEN)
+			} else if conn.IsDisconnected() && this.removeIdle(conn) {
+				this.evict(conn, STATE_IDLE, EVICT_DISCONNECTED)
+			}
+		}
+		this.replenish()
+
+		// 服务端不可用时，新连接很快又会进入 TransientFailure，
+		// 间隔一段时间再处理，避免反复拨号
+		timer := this.newTimer(time.Second)
+		select {
+		case <-this.done:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+	}
+}
+
+// 补充新连接，使连接总数（使用中的加空闲的）不少于 initSize，
+// 由 stateWatchCoroutine 和 healthCheckCoroutine 调用，同一时刻只有一个在补充（另一个直接返回），以免多拨号；
+// 和 Get 一样先占住名额，与并发的 Get 一起超出 peakSize 时放弃
+func (this *GRPCPool) replenish() {
+	if !atomic.CompareAndSwapInt32(&this.replenishing, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&this.replenishing, 0)
+
+	need := this.GetInitSize() - this.GetUsed() - this.GetIdle()
+	for i := int32(0); i < need; i++ {
+		if atomic.LoadInt32(&this.closed) == 1 {
+			return
+		}
+
+		conn, live := this.newConn()
+		if live > this.GetPeakSize() {
+			this.setState(conn, STATE_DIALING, STATE_CLOSED)
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), REPLENISH_DIAL_TIMEOUT)
+		_, err := this.dial(ctx, conn)
+		cancel()
+		if err != nil {
+			this.setState(conn, STATE_DIALING, STATE_CLOSED)
+			return
+		}
+		this.giveIdle(conn)
+	}
+}
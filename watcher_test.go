@@ -0,0 +1,174 @@
+package grpcpool_test
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+import (
+	"github.com/eyjian/grpcpool"
+	"github.com/eyjian/grpcpool/grpcpooltest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// 服务端的 MaxConnectionAge 到期后发送 GOAWAY：空闲的连接被监视协程剔除，使用中的在归还时剔除
+func TestGoAway(t *testing.T) {
+	server := grpcpooltest.NewServer()
+	server.SetServerOptions(grpc.KeepaliveParams(keepalive.ServerParameters{
+		MaxConnectionAge:      200 * time.Millisecond,
+		MaxConnectionAgeGrace: 100 * time.Millisecond,
+	}))
+	server.Restart()
+	pool := server.NewPool(0, 2, 4)
+	mo := new(grpcpool.DefaultMetricObserver)
+	pool.SetMetricObserver(mo)
+	hook := &recordHook{evicts: make(chan grpcpool.EvictReason, 4)}
+	pool.SetEventHook(hook)
+	t.Cleanup(func() {
+		pool.Close()
+		server.Close()
+	})
+	pool.EnableStateWatcher()
+
+	leased := mustGet(t, pool)
+	idle := mustGet(t, pool)
+	if errcode, err := pool.Put(idle); errcode != grpcpool.SUCCESS {
+		t.Fatalf("Put = %d, %v", errcode, err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !leased.IsDisconnected() || !idle.IsDisconnected() {
+		if time.Now().After(deadline) {
+			t.Fatalf("connections were not marked disconnected: leased %v, idle %v", leased.IsDisconnected(), idle.IsDisconnected())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if leased.IsBroken() {
+		t.Errorf("disconnected connection is broken")
+	}
+
+	// 空闲的被监视协程剔除
+	select {
+	case reason := <-hook.evicts:
+		if reason != grpcpool.EVICT_DISCONNECTED {
+			t.Errorf("idle evict reason = %s, want disconnected", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("disconnected idle connection was not evicted")
+	}
+	if !idle.IsClosed() {
+		t.Errorf("evicted idle connection is not closed")
+	}
+
+	// 使用中的在归还时剔除
+	if errcode, _ := pool.Put(leased); errcode != grpcpool.CONN_EXPIRED {
+		t.Errorf("Put = %d, want CONN_EXPIRED", errcode)
+	}
+	select {
+	case reason := <-hook.evicts:
+		if reason != grpcpool.EVICT_DISCONNECTED {
+			t.Errorf("leased evict reason = %s, want disconnected", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("disconnected leased connection was not evicted")
+	}
+	if metric := mo.Snapshot(false); metric.EvictDisconnected != 2 || metric.PutOld != 0 {
+		t.Errorf("EvictDisconnected = %d, PutOld = %d, want 2, 0", metric.EvictDisconnected, metric.PutOld)
+	}
+	checkCounts(t, pool, mo, 0, 0)
+}
+
+// 使用中的连接被标记后，归还时剔除并由 stateWatchCoroutine 补充新连接（间隔按池的时钟计时），
+// 正常剔除的连接进入 Shutdown 不会唤醒 stateWatchCoroutine
+func TestStateWatcherReplenish(t *testing.T) {
+	server, pool, mo, clock := newTestPool(t, 1, 2, 4)
+	pool.EnableStateWatcher()
+	pool.SetMaxLifetime(10)
+
+	// 正常剔除：存活超过 maxLifetime
+	conn := mustGet(t, pool)
+	clock.Advance(11 * time.Second)
+	if errcode, _ := pool.Put(conn); errcode != grpcpool.CONN_EXPIRED {
+		t.Fatalf("Put: errcode %d, want CONN_EXPIRED", errcode)
+	}
+	if !waitFor(func() bool { return conn.GetPoolState() == grpcpool.STATE_CLOSED }) {
+		t.Fatalf("expired conn is %s, want closed", conn.GetPoolState())
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := clock.GetWaiters(); n != 0 || conn.IsBroken() {
+		t.Fatalf("waiters %d, broken %v after a normal eviction, want 0, false", n, conn.IsBroken())
+	}
+
+	// 使用中的连接被断开
+	conn = mustGet(t, pool)
+	server.ResetConns()
+	if !waitFor(func() bool { return conn.IsBroken() || conn.IsDisconnected() }) {
+		t.Fatal("reset connection is neither broken nor disconnected")
+	}
+	// 标记时连接仍在使用中，不需补充，stateWatchCoroutine 进入间隔等待
+	if !clock.WaitForWaiters(1, time.Second) {
+		t.Fatal("stateWatchCoroutine is not waiting on the clock")
+	}
+	pool.Put(conn)
+	checkCounts(t, pool, mo, 0, 0)
+	if metric := mo.Snapshot(false); metric.EvictBroken+metric.EvictDisconnected != 1 || metric.PutClose != 0 || metric.PutOld != 1 {
+		t.Errorf("EvictBroken %d, EvictDisconnected %d, PutClose %d, PutOld %d, want 1 in total, 0, 1 (the expired one)", metric.EvictBroken, metric.EvictDisconnected, metric.PutClose, metric.PutOld)
+	}
+
+	// 间隔结束后处理归还时的通知，补充到 initSize
+	clock.Advance(time.Second)
+	if !waitFor(func() bool { return pool.GetIdle() == 1 }) {
+		t.Fatalf("idle = %d, want 1", pool.GetIdle())
+	}
+	checkCounts(t, pool, mo, 0, 1)
+}
+
+// 池被关闭时，未归还的连接的监视协程也退出，Close 返回前等待它们
+func TestStateWatcherClose(t *testing.T) {
+	_, pool, _, _ := newTestPool(t, 1, 2, 4)
+	pool.EnableStateWatcher()
+	conn := mustGet(t, pool)
+	if !waitFor(watching) {
+		t.Fatal("no watchCoroutine for the leased connection")
+	}
+
+	pool.Close()
+	if watching() {
+		t.Error("watchCoroutine still running after Close")
+	}
+	if conn.IsClosed() {
+		t.Error("leased connection closed by Close, want it closed when returned")
+	}
+	pool.Put(conn)
+}
+
+// 是否有 watchCoroutine 在运行
+func watching() bool {
+	buf := make([]byte, 1<<20)
+	return strings.Contains(string(buf[:runtime.Stack(buf, true)]), "grpcpool.(*GRPCPool).watchCoroutine")
+}
+
+// 补充新连接时与拨号中的 Get 一起占住名额，不会超出 peakSize
+func TestReplenishPeakSize(t *testing.T) {
+	server, pool, mo, _ := newTestPool(t, 2, 2, 2)
+	server.SetDialDelay(100 * time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.Replenish()
+	}()
+	// 补充的第一个连接正在拨号时，Get 占住剩下的名额
+	if !waitFor(func() bool { return pool.GetUsed() == 1 }) {
+		t.Fatal("replenish is not dialing")
+	}
+	conn := mustGet(t, pool)
+	<-done
+
+	if n := server.GetDialCount(); n != 2 {
+		t.Errorf("%d dials, want 2", n)
+	}
+	checkCounts(t, pool, mo, 1, 1)
+	pool.Put(conn)
+}